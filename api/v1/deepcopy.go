@@ -0,0 +1,118 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func (in *OrtRun) DeepCopyInto(out *OrtRun) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+func (in *OrtRun) DeepCopy() *OrtRun {
+	if in == nil {
+		return nil
+	}
+	out := new(OrtRun)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *OrtRun) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+func (in *OrtRunSpec) DeepCopyInto(out *OrtRunSpec) {
+	*out = *in
+	out.AnalyzerOptions = copyStringMap(in.AnalyzerOptions)
+	out.ScannerOptions = copyStringMap(in.ScannerOptions)
+}
+
+func (in *OrtRunSpec) DeepCopy() *OrtRunSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OrtRunSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *OrtRunStatus) DeepCopyInto(out *OrtRunStatus) {
+	*out = *in
+	out.AnalyzerStartedAt = in.AnalyzerStartedAt.DeepCopy()
+	out.AnalyzerFinishedAt = in.AnalyzerFinishedAt.DeepCopy()
+	out.ScannerStartedAt = in.ScannerStartedAt.DeepCopy()
+	out.ScannerFinishedAt = in.ScannerFinishedAt.DeepCopy()
+	out.ReporterStartedAt = in.ReporterStartedAt.DeepCopy()
+	out.ReporterFinishedAt = in.ReporterFinishedAt.DeepCopy()
+}
+
+func (in *OrtRunStatus) DeepCopy() *OrtRunStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OrtRunStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *OrtRunList) DeepCopyInto(out *OrtRunList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]OrtRun, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *OrtRunList) DeepCopy() *OrtRunList {
+	if in == nil {
+		return nil
+	}
+	out := new(OrtRunList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *OrtRunList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+func copyStringMap(in map[string]string) map[string]string {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for key, value := range in {
+		out[key] = value
+	}
+	return out
+}
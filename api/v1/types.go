@@ -0,0 +1,75 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// CreatedByAnnotation holds the identity of whoever created an OrtRun through the API or one of the chatbots
+	CreatedByAnnotation = "inocybe.io/created-by"
+	// SourceRunAnnotation holds the name of the OrtRun a rerun was created from
+	SourceRunAnnotation = "inocybe.io/source-run"
+	// StartStageAnnotation holds the stage a rerun should start from, reusing the results of the preceding stages of
+	// the source run. It is only a hint, the operator does not read it yet and runs all stages.
+	StartStageAnnotation = "inocybe.io/start-stage"
+)
+
+// OrtRun is a run of the ORT analyzer, scanner and reporter on a single repository
+type OrtRun struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OrtRunSpec   `json:"spec"`
+	Status OrtRunStatus `json:"status,omitempty"`
+}
+
+// OrtRunSpec describes what to scan and how
+type OrtRunSpec struct {
+	RepoUrl          string            `json:"repoUrl"`
+	Revision         string            `json:"revision,omitempty"`
+	Path             string            `json:"path,omitempty"`
+	AnalyzerOptions  map[string]string `json:"analyzerOptions,omitempty"`
+	ScannerOptions   map[string]string `json:"scannerOptions,omitempty"`
+	PackageCurations string            `json:"packageCurations,omitempty"`
+
+	// Abort tells the operator to stop the run
+	Abort bool `json:"abort,omitempty"`
+}
+
+// OrtRunStatus holds the status of each stage as reported by the operator, e.g. "Running" or "Succeeded"
+type OrtRunStatus struct {
+	Analyzer string `json:"analyzer,omitempty"`
+	Scanner  string `json:"scanner,omitempty"`
+	Reporter string `json:"reporter,omitempty"`
+
+	AnalyzerStartedAt  *metav1.Time `json:"analyzerStartedAt,omitempty"`
+	AnalyzerFinishedAt *metav1.Time `json:"analyzerFinishedAt,omitempty"`
+	ScannerStartedAt   *metav1.Time `json:"scannerStartedAt,omitempty"`
+	ScannerFinishedAt  *metav1.Time `json:"scannerFinishedAt,omitempty"`
+	ReporterStartedAt  *metav1.Time `json:"reporterStartedAt,omitempty"`
+	ReporterFinishedAt *metav1.Time `json:"reporterFinishedAt,omitempty"`
+}
+
+// OrtRunList is a list of OrtRuns
+type OrtRunList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []OrtRun `json:"items"`
+}
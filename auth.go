@@ -0,0 +1,288 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	authv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	tokenReviewTimeout = 10 * time.Second
+	tokenReviewTTL     = time.Minute
+)
+
+// identity is the authenticated caller of a request
+type identity struct {
+	name   string
+	groups []string
+	// kubernetes is true if name and groups are those of a Kubernetes user, e.g. of a ServiceAccount
+	kubernetes bool
+}
+
+type identityKey struct{}
+
+// authenticator checks a bearer token. It returns false if it does not recognize the token, so the next authenticator
+// can be tried, and an error only if it could not check the token at all.
+type authenticator interface {
+	authenticate(ctx context.Context, token string) (identity, bool, error)
+}
+
+// newAuthenticators creates the authenticators enabled by the config. If none are enabled, the API is open to anyone
+// who can reach it.
+func newAuthenticators(ctx context.Context, oc ortController, cfg authConfig) ([]authenticator, error) {
+	var authenticators []authenticator
+
+	if cfg.ApiKeysFile != "" {
+		keys, err := loadApiKeysFromFile(cfg.ApiKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, keys)
+	}
+
+	if cfg.ApiKeysSecret != "" {
+		keys, err := loadApiKeysFromSecret(ctx, oc.clientset, oc.defaultNamespace, cfg.ApiKeysSecret)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, keys)
+	}
+
+	if cfg.Oidc.Issuer != "" {
+		jwtAuth, err := newJwtAuthenticator(cfg.Oidc)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwtAuth)
+	}
+
+	if cfg.TokenReview {
+		authenticators = append(authenticators, newTokenReviewer(oc.clientset))
+	}
+
+	return authenticators, nil
+}
+
+// authenticate rejects requests without a bearer token accepted by one of the authenticators. Without authenticators,
+// all requests are let through. So are CORS preflight requests, since browsers send them without credentials. The
+// authenticators can be replaced at runtime.
+func authenticate(next http.Handler, authenticators *atomic.Pointer[[]authenticator]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := *authenticators.Load()
+
+		if r.Method == http.MethodOptions || len(current) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || token == "" {
+			writeUnauthorized(w, r, "missing bearer token")
+			return
+		}
+
+		for _, auth := range current {
+			id, ok, err := auth.authenticate(r.Context(), token)
+			if err != nil {
+				// the error is about the API, e.g. its own account or RBAC rules, not about the caller, so it is
+				// only logged
+				loggerFrom(r.Context()).Error("failed to authenticate the caller", "error", err)
+				writeProblem(w, http.StatusServiceUnavailable, "", allowedMethods(r.URL.Path))
+				return
+			}
+
+			if ok {
+				ctx := context.WithValue(r.Context(), identityKey{}, id)
+				ctx = withLogger(ctx, loggerFrom(ctx).With("user", id.name))
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		}
+
+		writeUnauthorized(w, r, "invalid bearer token")
+	})
+}
+
+func writeUnauthorized(w http.ResponseWriter, r *http.Request, detail string) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeProblem(w, http.StatusUnauthorized, detail, allowedMethods(r.URL.Path))
+}
+
+// identityFromContext returns the caller authenticated by the authenticate middleware, if any
+func identityFromContext(ctx context.Context) (identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(identity)
+	return id, ok
+}
+
+// callerName returns the name of the authenticated caller of the request or an empty string without authentication
+func callerName(r *http.Request) string {
+	id, _ := identityFromContext(r.Context())
+	return id.name
+}
+
+// apiKeys maps static API keys to the name of their owner
+type apiKeys map[string]string
+
+// loadApiKeysFromFile reads API keys from a file with lines of the form "<name>:<key>". Empty lines and lines starting
+// with '#' are ignored.
+func loadApiKeysFromFile(path string) (apiKeys, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := apiKeys{}
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, key, found := strings.Cut(line, ":")
+		if !found || name == "" || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected '<name>:<key>'", path, lineNumber)
+		}
+
+		keys[key] = name
+	}
+
+	return keys, scanner.Err()
+}
+
+// loadApiKeysFromSecret reads API keys from the Secret with the given name and namespace. Each entry of the Secret maps
+// the name of the owner to a key.
+func loadApiKeysFromSecret(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	namespace string,
+	name string,
+) (apiKeys, error) {
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API keys from Secret '%s': %w", name, err)
+	}
+
+	keys := apiKeys{}
+	for owner, key := range secret.Data {
+		keys[string(key)] = owner
+	}
+
+	return keys, nil
+}
+
+func (keys apiKeys) authenticate(_ context.Context, token string) (identity, bool, error) {
+	// compare against every key in constant time to not leak anything about the keys through timing
+	var owner string
+	for key, name := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1 {
+			owner = name
+		}
+	}
+
+	if owner == "" {
+		return identity{}, false, nil
+	}
+	return identity{name: owner}, true, nil
+}
+
+// tokenReviewer validates Kubernetes ServiceAccount tokens with the TokenReview API. Results are cached for a short
+// while, so not every request results in a call to the API server.
+type tokenReviewer struct {
+	clientset kubernetes.Interface
+	mu        *sync.Mutex
+	cache     map[[sha256.Size]byte]tokenReviewResult
+}
+
+type tokenReviewResult struct {
+	id            identity
+	authenticated bool
+	expires       time.Time
+}
+
+func newTokenReviewer(clientset kubernetes.Interface) tokenReviewer {
+	return tokenReviewer{
+		clientset: clientset,
+		mu:        &sync.Mutex{},
+		cache:     map[[sha256.Size]byte]tokenReviewResult{},
+	}
+}
+
+func (tr tokenReviewer) authenticate(ctx context.Context, token string) (identity, bool, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	tr.mu.Lock()
+	cached, found := tr.cache[key]
+	tr.mu.Unlock()
+
+	if found && now.Before(cached.expires) {
+		return cached.id, cached.authenticated, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tokenReviewTimeout)
+	defer cancel()
+
+	review := &authv1.TokenReview{Spec: authv1.TokenReviewSpec{Token: token}}
+	review, err := tr.clientset.AuthenticationV1().TokenReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return identity{}, false, fmt.Errorf("TokenReview failed: %w", err)
+	}
+
+	if review.Status.Error != "" && !review.Status.Authenticated {
+		loggerFrom(ctx).Warn("TokenReview rejected the token", "error", review.Status.Error)
+	}
+
+	result := tokenReviewResult{
+		id: identity{
+			name:       review.Status.User.Username,
+			groups:     review.Status.User.Groups,
+			kubernetes: true,
+		},
+		authenticated: review.Status.Authenticated,
+		expires:       now.Add(tokenReviewTTL),
+	}
+
+	tr.mu.Lock()
+	for k, r := range tr.cache {
+		if now.After(r.expires) {
+			delete(tr.cache, k)
+		}
+	}
+	tr.cache[key] = result
+	tr.mu.Unlock()
+
+	return result.id, result.authenticated, nil
+}
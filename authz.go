@@ -0,0 +1,160 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sigs.k8s.io/yaml"
+)
+
+// permission is an operation on OrtRuns a caller can be allowed to perform
+type permission string
+
+const (
+	// permRead covers listing, showing and watching runs and reading their logs
+	permRead permission = "read"
+	// permCreate covers creating and rerunning runs
+	permCreate permission = "create"
+	permDelete permission = "delete"
+	permAbort  permission = "abort"
+)
+
+var permissions = []permission{permRead, permCreate, permDelete, permAbort}
+
+// anyCaller in the users of a role binding matches every caller, including unauthenticated ones
+const anyCaller = "*"
+
+// policy grants permissions to callers through roles bound to user and group names. A nil policy allows everything, so
+// authorization is only enforced when a policy file is configured.
+type policy struct {
+	Roles    map[string][]permission `json:"roles"`
+	Bindings []roleBinding           `json:"bindings"`
+}
+
+// roleBinding grants the permissions of a role to all listed users and all members of the listed groups
+type roleBinding struct {
+	Role   string   `json:"role"`
+	Users  []string `json:"users,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// loadPolicy reads a policy from a YAML file like this:
+//
+//	roles:
+//	  viewer: [read]
+//	  admin: [read, create, delete, abort]
+//	bindings:
+//	  - role: admin
+//	    users: [alice, "@bob:matrix.org"]
+//	  - role: viewer
+//	    groups: [developers]
+func loadPolicy(path string) (*policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &policy{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file '%s': %w", path, err)
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy file '%s': %w", path, err)
+	}
+
+	return p, nil
+}
+
+func (p *policy) validate() error {
+	for role, perms := range p.Roles {
+		for _, perm := range perms {
+			if !isPermission(perm) {
+				return fmt.Errorf("role '%s' has unknown permission '%s'", role, perm)
+			}
+		}
+	}
+
+	for i, binding := range p.Bindings {
+		if _, found := p.Roles[binding.Role]; !found {
+			return fmt.Errorf("binding %d refers to unknown role '%s'", i, binding.Role)
+		}
+	}
+
+	return nil
+}
+
+// allows returns whether any role bound to the caller or one of their groups grants perm
+func (p *policy) allows(id identity, perm permission) bool {
+	if p == nil {
+		return true
+	}
+
+	for _, binding := range p.Bindings {
+		if !binding.matches(id) {
+			continue
+		}
+
+		for _, granted := range p.Roles[binding.Role] {
+			if granted == perm {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (rb roleBinding) matches(id identity) bool {
+	for _, user := range rb.Users {
+		if user == anyCaller || (id.name != "" && user == id.name) {
+			return true
+		}
+	}
+
+	for _, group := range rb.Groups {
+		for _, g := range id.groups {
+			if group == g {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func isPermission(perm permission) bool {
+	for _, p := range permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize writes a 403 response and returns false if the caller of the request lacks perm
+func (a api) authorize(w http.ResponseWriter, r *http.Request, perm permission, allowedMethods string) bool {
+	id, _ := identityFromContext(r.Context())
+	if a.policy.Load().allows(id, perm) {
+		return true
+	}
+
+	writeProblem(w, http.StatusForbidden, fmt.Sprintf("missing permission '%s'", perm), allowedMethods)
+	return false
+}
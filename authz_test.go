@@ -0,0 +1,167 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPolicyAllows(t *testing.T) {
+	pol := &policy{
+		Roles: map[string][]permission{
+			"viewer":  {permRead},
+			"creator": {permRead, permCreate},
+			"admin":   {permRead, permCreate, permDelete, permAbort},
+			"nothing": {},
+		},
+		Bindings: []roleBinding{
+			{Role: "admin", Users: []string{"alice"}},
+			{Role: "creator", Groups: []string{"developers", "testers"}},
+			{Role: "viewer", Users: []string{"*"}},
+			{Role: "nothing", Users: []string{"carol"}, Groups: []string{"guests"}},
+			{Role: "admin", Users: []string{""}},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		policy *policy
+		id     identity
+		perm   permission
+		want   bool
+	}{
+		{"nil policy allows anonymous callers", nil, identity{}, permDelete, true},
+		{"nil policy allows everyone", nil, identity{name: "mallory"}, permAbort, true},
+		{"user binding", pol, identity{name: "alice"}, permDelete, true},
+		{"user names are case-sensitive", pol, identity{name: "Alice"}, permDelete, false},
+		{"group binding", pol, identity{name: "bob", groups: []string{"testers"}}, permCreate, true},
+		{"one of several groups", pol, identity{name: "bob", groups: []string{"x", "developers"}}, permCreate, true},
+		{"group binding lacks permission", pol, identity{name: "bob", groups: []string{"developers"}}, permAbort, false},
+		{"user names don't match groups", pol, identity{name: "developers"}, permCreate, false},
+		{"group names don't match users", pol, identity{name: "bob", groups: []string{"alice"}}, permDelete, false},
+		{"wildcard matches everyone", pol, identity{name: "mallory"}, permRead, true},
+		{"wildcard matches anonymous callers", pol, identity{}, permRead, true},
+		{"wildcard grants only its role", pol, identity{name: "mallory"}, permCreate, false},
+		{"empty user does not match anonymous callers", pol, identity{}, permDelete, false},
+		{"role without permissions", pol, identity{name: "carol", groups: []string{"guests"}}, permCreate, false},
+		{"permissions of several bindings add up", pol, identity{name: "alice", groups: []string{"guests"}}, permAbort, true},
+		{"empty policy allows nothing", &policy{}, identity{name: "alice"}, permRead, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.allows(tt.id, tt.perm); got != tt.want {
+				t.Errorf("allows(%+v, %s) = %v, want %v", tt.id, tt.perm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			yaml: "roles:\n  viewer: [read]\nbindings:\n  - role: viewer\n    groups: [developers]\n",
+		},
+		{
+			name:    "unknown permission",
+			yaml:    "roles:\n  viewer: [read, write]\n",
+			wantErr: "unknown permission 'write'",
+		},
+		{
+			name:    "unknown role",
+			yaml:    "roles:\n  viewer: [read]\nbindings:\n  - role: admin\n    users: [alice]\n",
+			wantErr: "unknown role 'admin'",
+		},
+		{
+			name:    "unknown field",
+			yaml:    "roles:\n  viewer: [read]\nbindings:\n  - role: viewer\n    user: [alice]\n",
+			wantErr: "unknown field",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.yaml")
+			if err := os.WriteFile(path, []byte(tt.yaml), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := loadPolicy(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("loadPolicy() failed: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadPolicy() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	pol := &atomic.Pointer[policy]{}
+	pol.Store(&policy{
+		Roles:    map[string][]permission{"viewer": {permRead}},
+		Bindings: []roleBinding{{Role: "viewer", Groups: []string{"developers"}}},
+	})
+	a := api{policy: pol}
+
+	tests := []struct {
+		name       string
+		id         *identity
+		perm       permission
+		wantStatus int
+	}{
+		{"member of a bound group", &identity{name: "bob", groups: []string{"developers"}}, permRead, http.StatusOK},
+		{"missing permission", &identity{name: "bob", groups: []string{"developers"}}, permCreate, http.StatusForbidden},
+		{"no binding", &identity{name: "mallory"}, permRead, http.StatusForbidden},
+		{"unauthenticated", nil, permRead, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/runs", nil)
+			if tt.id != nil {
+				r = r.WithContext(context.WithValue(r.Context(), identityKey{}, *tt.id))
+			}
+
+			w := httptest.NewRecorder()
+			if a.authorize(w, r, tt.perm, "GET") {
+				w.WriteHeader(http.StatusOK)
+			}
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -17,34 +17,37 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime"
-	"log"
 	"strings"
+	"time"
 )
 
 const chatbotHelpText = `
-create <repoURL> - Create an OrtRun resource with repoURL
-list - List all OrtRun resources
-show <name> - Show the nitty gritty of an OrtRun`
-
-var runTableHeaders = []string{"Name", "Scanned Repository", "Analyzer Status", "Scanner Status", "Reporter Status", "Report URL"}
-
-type ortRunMeta struct {
-	name           string
-	repoUrl        string
-	analyzerStatus string
-	scannerStatus  string
-	reporterStatus string
+create <repoURL> [option=value ...] - Create an OrtRun resource with repoURL. Options:
+    revision=<branch, tag or commit> path=<subdirectory> curations=<ConfigMap name>
+    analyzer.<ORT option>=<value> scanner.<ORT option>=<value> label.<name>=<value>
+list [--all-namespaces] - List all OrtRun resources, optionally in all namespaces
+show <name> - Show the nitty gritty of an OrtRun
+abort <name> - Abort an OrtRun and stop all stages that have not finished yet
+rerun <name> [stage] - Create a new OrtRun from an existing one, optionally marked to restart from the scanner or reporter
+All commands accept --namespace <namespace> and --cluster <cluster> to use another namespace or cluster.`
+
+var runTableHeaders = []string{
+	"Name",
+	"Namespace",
+	"Cluster",
+	"Scanned Repository",
+	"Phase",
+	"Created",
+	"Analyzer Status",
+	"Scanner Status",
+	"Reporter Status",
+	"Report URL",
 }
 
-func runListToHtml(unstructuredRuns *unstructured.UnstructuredList) (string, error) {
-	runs, err := parseRunList(unstructuredRuns)
-	if err != nil {
-		return "", err
-	}
-
+// runListToHtml formats the runs as HTML table, linking to the report of each run with reportUrl as template
+func runListToHtml(runs OrtRunList, reportUrlTemplate string) string {
 	sb := strings.Builder{}
 	sb.WriteString("<table>")
 	sb.WriteString("<tr>")
@@ -55,78 +58,124 @@ func runListToHtml(unstructuredRuns *unstructured.UnstructuredList) (string, err
 
 	sb.WriteString("</tr>")
 
-	for _, run := range runs {
+	for _, run := range runs.Runs {
 		sb.WriteString("<tr>")
 
-		repoUrl := fmt.Sprintf(`<a href="%s">%s</a>`, run.repoUrl, run.repoUrl)
+		repoUrl := fmt.Sprintf(`<a href="%s">%s</a>`, run.RepoUrl, run.RepoUrl)
 		reportUrl := "n/a"
 
-		if run.reporterStatus == Succeeded.String() {
-			reportUrl = fmt.Sprintf("https://ortruns.inocybe.io/%s", run.name)
+		if run.Status.Reporter == Succeeded {
+			reportUrl = reportUrlFor(reportUrlTemplate, run.Name, run.Namespace, run.Cluster)
 			reportUrl = fmt.Sprintf(`<a href="%s">%s</a>`, reportUrl, reportUrl)
 		}
 
-		sb.WriteString(fmt.Sprintf("<td>%s</td>", run.name))
+		sb.WriteString(fmt.Sprintf("<td>%s</td>", run.Name))
+		sb.WriteString(fmt.Sprintf("<td>%s</td>", run.Namespace))
+		sb.WriteString(fmt.Sprintf("<td>%s</td>", run.Cluster))
 		sb.WriteString(fmt.Sprintf("<td>%s</td>", repoUrl))
-		sb.WriteString(fmt.Sprintf("<td>%s</td>", run.analyzerStatus))
-		sb.WriteString(fmt.Sprintf("<td>%s</td>", run.scannerStatus))
-		sb.WriteString(fmt.Sprintf("<td>%s</td>", run.reporterStatus))
+		sb.WriteString(fmt.Sprintf("<td>%s</td>", run.Phase))
+		sb.WriteString(fmt.Sprintf("<td>%s</td>", run.CreatedAt.UTC().Format(time.RFC3339)))
+		sb.WriteString(fmt.Sprintf("<td>%s</td>", stageCell(run.Status.Analyzer, run.Timings.Analyzer)))
+		sb.WriteString(fmt.Sprintf("<td>%s</td>", stageCell(run.Status.Scanner, run.Timings.Scanner)))
+		sb.WriteString(fmt.Sprintf("<td>%s</td>", stageCell(run.Status.Reporter, run.Timings.Reporter)))
 		sb.WriteString(fmt.Sprintf("<td>%s</td>", reportUrl))
 
 		sb.WriteString("</tr>")
 	}
 
 	sb.WriteString("</table>")
-	return sb.String(), nil
+	return sb.String()
 }
 
-func parseRunList(runs *unstructured.UnstructuredList) ([]ortRunMeta, error) {
-	parsedRuns := []ortRunMeta{}
+// stageCell formats the status of a stage together with its duration, if it has started
+func stageCell(status StageStatus, timing StageTiming) string {
+	if timing.StartedAt == nil {
+		return status.String()
+	}
+	return fmt.Sprintf("%s (%s)", status, timing.duration())
+}
 
-	err := runs.EachListItem(func(obj runtime.Object) error {
-		item := obj.(*unstructured.Unstructured)
+// parseOption removes the option "--<name> <value>" or "--<name>=<value>" from the arguments of a command, e.g.
+// "--namespace". It returns the value, which is empty if the option is not given, and the remaining arguments.
+func parseOption(arguments, name string) (string, string, error) {
+	option := "--" + name
+	fields := strings.Fields(arguments)
+	remaining := make([]string, 0, len(fields))
+	result := ""
+
+	for i := 0; i < len(fields); i++ {
+		value, found := strings.CutPrefix(fields[i], option+"=")
+
+		if fields[i] == option && i+1 < len(fields) {
+			value, found = fields[i+1], true
+			i++
+		} else if fields[i] == option {
+			return "", "", fmt.Errorf("missing value for %s", option)
+		}
 
-		status, found, err := unstructured.NestedStringMap(item.Object, "status")
 		if !found {
-			log.Printf("k8s object does not contain field 'status': %v\n", item)
-			return nil
+			remaining = append(remaining, fields[i])
+			continue
 		}
-		if err != nil {
-			log.Printf("fed the wrong thing to unstructured.NestedStringMap(): %v\n", err)
-			return nil
+
+		if value == "" {
+			return "", "", fmt.Errorf("missing value for %s", option)
 		}
+		result = value
+	}
+
+	return result, strings.Join(remaining, " "), nil
+}
+
+// parseCreateArguments parses the arguments of the create command into a CreateRunRequest, without validating it
+func parseCreateArguments(arguments string) (CreateRunRequest, error) {
+	fields := strings.Fields(arguments)
+	if len(fields) == 0 {
+		return CreateRunRequest{}, errors.New("missing repository URL")
+	}
+
+	req := CreateRunRequest{RepoUrl: fields[0]}
 
-		metadata, found, err := unstructured.NestedMap(item.Object, "metadata")
+	for _, arg := range fields[1:] {
+		key, value, found := strings.Cut(arg, "=")
 		if !found {
-			log.Printf("k8s object does not contain field 'metadata': %v\n", item)
-			return nil
-		}
-		if err != nil {
-			log.Printf("fed the wrong thing to unstructured.NestedMap(): %v\n", err)
-			return nil
+			return req, fmt.Errorf("invalid option '%s', expected option=value", arg)
 		}
 
-		spec, found, err := unstructured.NestedStringMap(item.Object, "spec")
-		if !found {
-			log.Printf("k8s object does not contain field 'spec': %v\n", item)
-			return nil
+		if option, found := strings.CutPrefix(key, "analyzer."); found {
+			req.AnalyzerOptions = setOption(req.AnalyzerOptions, option, value)
+			continue
 		}
-		if err != nil {
-			log.Printf("fed the wrong thing to unstructured.NestedStringMap(): %v\n", err)
-			return nil
+
+		if option, found := strings.CutPrefix(key, "scanner."); found {
+			req.ScannerOptions = setOption(req.ScannerOptions, option, value)
+			continue
 		}
 
-		pr := ortRunMeta{}
-		pr.name = metadata["name"].(string)
-		pr.repoUrl = spec["repoUrl"]
-		pr.analyzerStatus = status["analyzer"]
-		pr.scannerStatus = status["scanner"]
-		pr.reporterStatus = status["reporter"]
+		if label, found := strings.CutPrefix(key, "label."); found {
+			req.Labels = setOption(req.Labels, label, value)
+			continue
+		}
 
-		parsedRuns = append(parsedRuns, pr)
+		switch key {
+		case "revision":
+			req.Revision = value
+		case "path":
+			req.Path = value
+		case "curations":
+			req.PackageCurations = value
+		default:
+			return req, fmt.Errorf("unknown option '%s'", key)
+		}
+	}
 
-		return nil
-	})
+	return req, nil
+}
 
-	return parsedRuns, err
+func setOption(options map[string]string, key, value string) map[string]string {
+	if options == nil {
+		options = map[string]string{}
+	}
+	options[key] = value
+	return options
 }
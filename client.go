@@ -0,0 +1,226 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	ortv1 "github.com/haikoschol/ort-operator-api/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(ortv1.AddToScheme(scheme))
+}
+
+// ortRunClient is a typed client for the OrtRuns in a single namespace. It wraps the dynamic client and an informer
+// cache and converts their unstructured objects to ortv1.OrtRun. Malformed objects result in errors or are skipped in
+// lists, instead of bringing down the whole server. Without an informer, all reads go to the API server.
+type ortRunClient struct {
+	resource  dynamic.ResourceInterface
+	informers dynamicinformer.DynamicSharedInformerFactory
+	informer  cache.SharedIndexInformer
+	lister    cache.GenericNamespaceLister
+}
+
+func newOrtRunClient(dynClient dynamic.Interface, namespace string) ortRunClient {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynClient, 0, namespace, nil)
+	informer := factory.ForResource(ortv1.Resource)
+
+	return ortRunClient{
+		resource:  dynClient.Resource(ortv1.Resource).Namespace(namespace),
+		informers: factory,
+		informer:  informer.Informer(),
+		lister:    informer.Lister().ByNamespace(namespace),
+	}
+}
+
+// newUncachedOrtRunClient creates an ortRunClient without informer cache, e.g. for a client impersonating a user
+func newUncachedOrtRunClient(dynClient dynamic.Interface, namespace string) ortRunClient {
+	return ortRunClient{resource: dynClient.Resource(ortv1.Resource).Namespace(namespace)}
+}
+
+// start runs the informer until stop is closed
+func (c ortRunClient) start(stop <-chan struct{}) {
+	c.informers.Start(stop)
+}
+
+// list returns the OrtRuns matching selector from the informer cache
+func (c ortRunClient) list(ctx context.Context, selector labels.Selector) (*ortv1.OrtRunList, error) {
+	if c.lister == nil {
+		return c.listFromServer(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	}
+
+	objects, err := c.lister.List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := &ortv1.OrtRunList{}
+
+	for _, obj := range objects {
+		run, err := ortRunFromObject(obj)
+		if err != nil {
+			loggerFrom(ctx).Warn("skipping OrtRun that can not be decoded", "error", err)
+			continue
+		}
+
+		runs.Items = append(runs.Items, *run)
+	}
+
+	return runs, nil
+}
+
+// listFromServer lists OrtRuns from the API server, bypassing the informer cache
+func (c ortRunClient) listFromServer(ctx context.Context, opts metav1.ListOptions) (*ortv1.OrtRunList, error) {
+	list, err := c.resource.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := &ortv1.OrtRunList{}
+	runs.ResourceVersion = list.GetResourceVersion()
+	runs.Continue = list.GetContinue()
+	runs.RemainingItemCount = list.GetRemainingItemCount()
+
+	for i := range list.Items {
+		run, err := ortRunFromUnstructured(&list.Items[i])
+		if err != nil {
+			loggerFrom(ctx).Warn("skipping OrtRun that can not be decoded", "run", list.Items[i].GetName(), "error", err)
+			continue
+		}
+
+		runs.Items = append(runs.Items, *run)
+	}
+
+	return runs, nil
+}
+
+// get returns the OrtRun with the given name from the informer cache. If it is not in the cache, e.g. because it was
+// just created, it is fetched from the API server instead.
+func (c ortRunClient) get(ctx context.Context, name string) (*ortv1.OrtRun, error) {
+	if c.lister != nil {
+		if obj, err := c.lister.Get(name); err == nil {
+			return ortRunFromObject(obj)
+		}
+	}
+
+	resource, err := c.resource.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return ortRunFromUnstructured(resource)
+}
+
+func (c ortRunClient) create(ctx context.Context, run *ortv1.OrtRun) (*ortv1.OrtRun, error) {
+	resource, err := ortRunToUnstructured(run)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := c.resource.Create(ctx, resource, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return ortRunFromUnstructured(created)
+}
+
+func (c ortRunClient) patch(ctx context.Context, name string, pt types.PatchType, data []byte) (*ortv1.OrtRun, error) {
+	patched, err := c.resource.Patch(ctx, name, pt, data, metav1.PatchOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return ortRunFromUnstructured(patched)
+}
+
+func (c ortRunClient) delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.resource.Delete(ctx, name, opts)
+}
+
+// watch watches OrtRuns on the API server. The objects of all events except errors are of type *ortv1.OrtRun.
+// Malformed OrtRuns are skipped.
+func (c ortRunClient) watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	watcher, err := c.resource.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return watch.Filter(watcher, func(event watch.Event) (watch.Event, bool) {
+		if event.Type == watch.Error {
+			return event, true
+		}
+
+		run, err := ortRunFromObject(event.Object)
+		if err != nil {
+			loggerFrom(ctx).Warn("skipping OrtRun that can not be decoded", "error", err)
+			return event, false
+		}
+
+		event.Object = run
+		return event, true
+	}), nil
+}
+
+func ortRunFromObject(obj runtime.Object) (*ortv1.OrtRun, error) {
+	resource, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object of type %T instead of OrtRun", obj)
+	}
+
+	return ortRunFromUnstructured(resource)
+}
+
+func ortRunFromUnstructured(resource *unstructured.Unstructured) (*ortv1.OrtRun, error) {
+	run := &ortv1.OrtRun{}
+
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, run); err != nil {
+		return nil, fmt.Errorf("malformed OrtRun '%s': %w", resource.GetName(), err)
+	}
+
+	return run, nil
+}
+
+func ortRunToUnstructured(run *ortv1.OrtRun) (*unstructured.Unstructured, error) {
+	run = run.DeepCopy()
+
+	kinds, _, err := scheme.ObjectKinds(run)
+	if err != nil {
+		return nil, err
+	}
+	run.SetGroupVersionKind(kinds[0])
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(run)
+	if err != nil {
+		return nil, err
+	}
+
+	return &unstructured.Unstructured{Object: obj}, nil
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"log/slog"
+	"sync"
+)
+
+var (
+	errUnknownCluster  = errors.New("unknown cluster")
+	errClusterNotReady = errors.New("the caches of the cluster are not filled yet")
+)
+
+// clusterConfig describes how to reach a cluster, either with the in-cluster config or with a context of a kubeconfig.
+// If neither is given, the in-cluster config is tried first and the default kubeconfig second.
+type clusterConfig struct {
+	Name       string `json:"name"`
+	InCluster  bool   `json:"inCluster,omitempty"`
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+}
+
+func (cc clusterConfig) restConfig() (*rest.Config, error) {
+	if cc.InCluster {
+		return rest.InClusterConfig()
+	}
+
+	if cc.Kubeconfig == "" && cc.Context == "" {
+		return loadConfig()
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = cc.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: cc.Context}
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+func validateClusterConfigs(configs []clusterConfig) error {
+	if len(configs) == 0 {
+		return errors.New("no clusters configured")
+	}
+
+	seen := map[string]bool{}
+
+	for i, cc := range configs {
+		if cc.Name == "" {
+			return fmt.Errorf("cluster %d has no name", i)
+		}
+
+		if seen[cc.Name] {
+			return fmt.Errorf("duplicate cluster '%s'", cc.Name)
+		}
+		seen[cc.Name] = true
+
+		if cc.InCluster && (cc.Kubeconfig != "" || cc.Context != "") {
+			return fmt.Errorf("cluster '%s' can not use inCluster together with kubeconfig or context", cc.Name)
+		}
+	}
+
+	return nil
+}
+
+// clusters holds an ortController for each configured cluster. The first cluster is the default one.
+type clusters struct {
+	names       []string
+	controllers map[string]ortController
+}
+
+func newClusters(configs []clusterConfig, defaultNamespace string, namespaces []string) (clusters, error) {
+	c := clusters{controllers: map[string]ortController{}}
+
+	for _, cc := range configs {
+		config, err := cc.restConfig()
+		if err != nil {
+			return c, fmt.Errorf("failed to load config of cluster '%s': %w", cc.Name, err)
+		}
+
+		oc, err := newOrtController(cc.Name, config, defaultNamespace, namespaces)
+		if err != nil {
+			return c, fmt.Errorf("failed to create controller for cluster '%s': %w", cc.Name, err)
+		}
+
+		c.names = append(c.names, cc.Name)
+		c.controllers[cc.Name] = oc
+	}
+
+	return c, nil
+}
+
+// start starts the controllers of all clusters and waits for their caches to be filled. A cluster whose caches don't
+// fill in time, e.g. because it can not be reached, is logged and reported by /readyz, while the others are served. Its
+// informers keep trying, so it is served once its caches are filled. start only fails if no cluster can be served.
+func (c clusters) start(stop <-chan struct{}) error {
+	errs := make([]error, len(c.names))
+	var wg sync.WaitGroup
+
+	for i, name := range c.names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			if err := c.controllers[name].start(stop); err != nil {
+				errs[i] = fmt.Errorf("cluster '%s': %w", name, err)
+			}
+		}(i, name)
+	}
+
+	wg.Wait()
+
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			slog.Error("cluster is not ready", "cluster", c.names[i], "error", err)
+		}
+	}
+
+	if failed == len(c.names) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// setAllowedNamespaces changes the allowlist of namespaces of all clusters at runtime
+func (c clusters) setAllowedNamespaces(namespaces []string) {
+	for _, name := range c.names {
+		c.controllers[name].setAllowedNamespaces(namespaces)
+	}
+}
+
+// controller returns the ortController of the cluster with the given name or of the default cluster, if name is empty
+func (c clusters) controller(name string) (ortController, error) {
+	if name == "" {
+		name = c.names[0]
+	}
+
+	oc, found := c.controllers[name]
+	if !found {
+		return oc, fmt.Errorf("%w: '%s'", errUnknownCluster, name)
+	}
+
+	// the caches would answer with no OrtRuns at all
+	if !oc.cachesSynced() {
+		return oc, fmt.Errorf("%w: '%s'", errClusterNotReady, name)
+	}
+	return oc, nil
+}
+
+func (c clusters) defaultController() ortController {
+	return c.controllers[c.names[0]]
+}
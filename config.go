@@ -0,0 +1,405 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"log/slog"
+	"net/url"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
+)
+
+// config is the configuration of the API and the bots. Each setting is taken from the first of these that has it:
+// command line flags, environment variables, the YAML config file and the defaults.
+type config struct {
+	Listen     string          `json:"listen"`
+	TLS        tlsConfig       `json:"tls"`
+	Namespace  string          `json:"namespace"`
+	Namespaces []string        `json:"namespaces,omitempty"`
+	Kubeconfig string          `json:"kubeconfig,omitempty"`
+	Context    string          `json:"context,omitempty"`
+	Clusters   []clusterConfig `json:"clusters,omitempty"`
+	// ReportUrl is the URL of the report of a run, with the placeholders {name}, {namespace} and {cluster}
+	ReportUrl     string              `json:"reportUrl"`
+	Auth          authConfig          `json:"auth"`
+	Impersonation impersonationConfig `json:"impersonation"`
+	Matrix        matrixConfig        `json:"matrix"`
+	Slack         slackConfig         `json:"slack"`
+	Tracing       tracingConfig       `json:"tracing"`
+	// LogLevel is the minimum level of logged records: debug, info, warn or error
+	LogLevel string `json:"logLevel"`
+
+	// file is the path of the config file, if one was read
+	file string
+}
+
+type tlsConfig struct {
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+}
+
+type authConfig struct {
+	ApiKeysFile   string     `json:"apiKeysFile,omitempty"`
+	ApiKeysSecret string     `json:"apiKeysSecret,omitempty"`
+	TokenReview   bool       `json:"tokenReview,omitempty"`
+	Oidc          oidcConfig `json:"oidc"`
+	PolicyFile    string     `json:"policyFile,omitempty"`
+}
+
+type oidcConfig struct {
+	Issuer      string `json:"issuer,omitempty"`
+	Jwks        string `json:"jwks,omitempty"`
+	Audience    string `json:"audience,omitempty"`
+	GroupsClaim string `json:"groupsClaim"`
+}
+
+type impersonationConfig struct {
+	Enabled     bool   `json:"enabled,omitempty"`
+	UserPrefix  string `json:"userPrefix,omitempty"`
+	GroupPrefix string `json:"groupPrefix,omitempty"`
+}
+
+// matrixConfig configures the Matrix bot, which runs if Server is set
+type matrixConfig struct {
+	Server      string `json:"server,omitempty"`
+	User        string `json:"user,omitempty"`
+	AccessToken string `json:"accessToken,omitempty"`
+}
+
+// slackConfig configures the experimental Slack bot, which only runs if Enabled is true
+type slackConfig struct {
+	Enabled   bool   `json:"enabled,omitempty"`
+	Token     string `json:"token,omitempty"`
+	ChannelId string `json:"channelId,omitempty"`
+}
+
+// tracingConfig configures the exporter of OpenTelemetry spans. Without exporter, no spans are recorded.
+type tracingConfig struct {
+	// Exporter is "otlp", "stdout" or "file"
+	Exporter string `json:"exporter,omitempty"`
+	// Endpoint is the host and port of the OTLP/HTTP receiver. The exporter defaults to localhost:4318.
+	Endpoint string `json:"endpoint,omitempty"`
+	Insecure bool   `json:"insecure,omitempty"`
+	// File is the file the "file" exporter appends spans to
+	File string `json:"file,omitempty"`
+}
+
+func defaultConfig() config {
+	return config{
+		Listen:    ":4000",
+		Namespace: "ort",
+		ReportUrl: "https://ortruns.inocybe.io/{name}",
+		LogLevel:  "info",
+		Auth: authConfig{
+			Oidc: oidcConfig{GroupsClaim: "groups"},
+		},
+	}
+}
+
+// setting is a configuration setting that can be given as a command line flag or an environment variable. field returns
+// a pointer to the string, bool or string slice in the config it sets.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	field func(c *config) interface{}
+}
+
+var settings = []setting{
+	{"listen", "LISTEN_ADDRESS", "address to listen on",
+		func(c *config) interface{} { return &c.Listen }},
+	{"tls-cert-file", "TLS_CERT_FILE", "certificate for serving HTTPS",
+		func(c *config) interface{} { return &c.TLS.CertFile }},
+	{"tls-key-file", "TLS_KEY_FILE", "private key for serving HTTPS",
+		func(c *config) interface{} { return &c.TLS.KeyFile }},
+	{"namespace", "ORT_NAMESPACE", "default namespace of OrtRuns",
+		func(c *config) interface{} { return &c.Namespace }},
+	{"namespaces", "ORT_NAMESPACES", "comma-separated further namespaces to allow",
+		func(c *config) interface{} { return &c.Namespaces }},
+	// not KUBECONFIG, which may list several files and is left to the default loading rules of client-go
+	{"kubeconfig", "ORT_KUBECONFIG", "path of the kubeconfig",
+		func(c *config) interface{} { return &c.Kubeconfig }},
+	{"context", "KUBE_CONTEXT", "context of the kubeconfig to use",
+		func(c *config) interface{} { return &c.Context }},
+	{"report-url", "REPORT_URL", "URL template of reports",
+		func(c *config) interface{} { return &c.ReportUrl }},
+	{"auth-api-keys-file", "AUTH_API_KEYS_FILE", "file with API keys",
+		func(c *config) interface{} { return &c.Auth.ApiKeysFile }},
+	{"auth-api-keys-secret", "AUTH_API_KEYS_SECRET", "Secret with API keys",
+		func(c *config) interface{} { return &c.Auth.ApiKeysSecret }},
+	{"auth-token-review", "AUTH_TOKEN_REVIEW", "accept ServiceAccount tokens",
+		func(c *config) interface{} { return &c.Auth.TokenReview }},
+	{"auth-oidc-issuer", "AUTH_OIDC_ISSUER", "issuer of accepted JWTs",
+		func(c *config) interface{} { return &c.Auth.Oidc.Issuer }},
+	{"auth-oidc-jwks", "AUTH_OIDC_JWKS", "URL or path of the JWKS",
+		func(c *config) interface{} { return &c.Auth.Oidc.Jwks }},
+	{"auth-oidc-audience", "AUTH_OIDC_AUDIENCE", "required audience of JWTs",
+		func(c *config) interface{} { return &c.Auth.Oidc.Audience }},
+	{"auth-oidc-groups-claim", "AUTH_OIDC_GROUPS_CLAIM", "JWT claim holding groups",
+		func(c *config) interface{} { return &c.Auth.Oidc.GroupsClaim }},
+	{"auth-policy-file", "AUTH_POLICY_FILE", "file with the authorization policy",
+		func(c *config) interface{} { return &c.Auth.PolicyFile }},
+	{"impersonation", "K8S_IMPERSONATION", "impersonate callers in Kubernetes",
+		func(c *config) interface{} { return &c.Impersonation.Enabled }},
+	{"impersonation-user-prefix", "K8S_IMPERSONATION_USER_PREFIX", "prefix of impersonated users",
+		func(c *config) interface{} { return &c.Impersonation.UserPrefix }},
+	{"impersonation-group-prefix", "K8S_IMPERSONATION_GROUP_PREFIX", "prefix of impersonated groups",
+		func(c *config) interface{} { return &c.Impersonation.GroupPrefix }},
+	{"matrix-server", "MATRIX_SERVER", "Matrix homeserver of the bot",
+		func(c *config) interface{} { return &c.Matrix.Server }},
+	{"matrix-user", "MATRIX_USER", "Matrix user of the bot",
+		func(c *config) interface{} { return &c.Matrix.User }},
+	{"matrix-access-token", "MATRIX_ACCESS_TOKEN", "Matrix access token of the bot",
+		func(c *config) interface{} { return &c.Matrix.AccessToken }},
+	{"slack", "SLACK_ENABLED", "run the experimental Slack bot",
+		func(c *config) interface{} { return &c.Slack.Enabled }},
+	{"slack-token", "SLACK_TOKEN", "Slack token of the bot",
+		func(c *config) interface{} { return &c.Slack.Token }},
+	{"slack-channel-id", "SLACK_CHANNEL_ID", "Slack channel of the bot",
+		func(c *config) interface{} { return &c.Slack.ChannelId }},
+	{"tracing-exporter", "TRACING_EXPORTER", "exporter of traces: otlp, stdout or file",
+		func(c *config) interface{} { return &c.Tracing.Exporter }},
+	{"tracing-endpoint", "TRACING_ENDPOINT", "host:port of the OTLP/HTTP receiver",
+		func(c *config) interface{} { return &c.Tracing.Endpoint }},
+	{"tracing-insecure", "TRACING_INSECURE", "send traces to the OTLP receiver without TLS",
+		func(c *config) interface{} { return &c.Tracing.Insecure }},
+	{"tracing-file", "TRACING_FILE", "file the file exporter appends traces to",
+		func(c *config) interface{} { return &c.Tracing.File }},
+	{"log-level", "LOG_LEVEL", "minimum level of logged records: debug, info, warn or error",
+		func(c *config) interface{} { return &c.LogLevel }},
+}
+
+// set parses value according to the type of the setting and stores it in c. Lists are comma-separated.
+func (s setting) set(c *config, value string) error {
+	switch field := s.field(c).(type) {
+	case *string:
+		*field = value
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got '%s'", value)
+		}
+		*field = b
+	case *[]string:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field = items
+	default:
+		return fmt.Errorf("unsupported type %T", field)
+	}
+	return nil
+}
+
+func (s setting) isBool() bool {
+	_, ok := s.field(&config{}).(*bool)
+	return ok
+}
+
+// flagValue records the values of a flag, so flags can be applied after the config file and the environment
+type flagValue struct {
+	setting setting
+	values  *[]settingValue
+}
+
+type settingValue struct {
+	setting setting
+	value   string
+}
+
+func (fv flagValue) String() string {
+	return ""
+}
+
+func (fv flagValue) Set(value string) error {
+	*fv.values = append(*fv.values, settingValue{fv.setting, value})
+	return nil
+}
+
+// IsBoolFlag allows bool flags to be given without value, like -impersonation
+func (fv flagValue) IsBoolFlag() bool {
+	return fv.setting.isBool()
+}
+
+// readConfig reads the config file given with the flag -config or the environment variable CONFIG_FILE, applies the
+// environment variables and the flags in args on top and validates the result
+func readConfig(args []string) (config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("ort-operator-api", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path of the YAML config file (env CONFIG_FILE)")
+	var flagValues []settingValue
+
+	for _, s := range settings {
+		fs.Var(flagValue{s, &flagValues}, s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *configFile != "" {
+		cfg.file = *configFile
+
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return cfg, err
+		}
+
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse config file '%s': %w", *configFile, err)
+		}
+	}
+
+	for _, s := range settings {
+		if value, found := os.LookupEnv(s.env); found {
+			if err := s.set(&cfg, value); err != nil {
+				return cfg, fmt.Errorf("invalid value of %s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, fv := range flagValues {
+		if err := fv.setting.set(&cfg, fv.value); err != nil {
+			return cfg, fmt.Errorf("invalid value of -%s: %w", fv.setting.flag, err)
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	return cfg, nil
+}
+
+// validate returns all problems of the config at once, one per line
+func (c config) validate() error {
+	var errs []error
+	addError := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("  "+format, args...))
+	}
+
+	if c.Listen == "" {
+		addError("listen: must not be empty")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		addError("tls: certFile and keyFile must be given together")
+	}
+
+	files := []struct{ name, path string }{
+		{"tls.certFile", c.TLS.CertFile},
+		{"tls.keyFile", c.TLS.KeyFile},
+		{"kubeconfig", c.Kubeconfig},
+		{"auth.apiKeysFile", c.Auth.ApiKeysFile},
+		{"auth.policyFile", c.Auth.PolicyFile},
+	}
+
+	for _, file := range files {
+		if _, err := os.Stat(file.path); file.path != "" && err != nil {
+			addError("%s: %v", file.name, err)
+		}
+	}
+
+	for _, ns := range append([]string{c.Namespace}, c.Namespaces...) {
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			addError("namespace '%s': %s", ns, msg)
+		}
+	}
+
+	if len(c.Clusters) > 0 {
+		if c.Kubeconfig != "" || c.Context != "" {
+			addError("kubeconfig and context can not be combined with clusters, set them on each cluster instead")
+		}
+
+		if err := validateClusterConfigs(c.Clusters); err != nil {
+			addError("clusters: %v", err)
+		}
+	}
+
+	if !strings.Contains(c.ReportUrl, "{name}") {
+		addError("reportUrl: must contain the placeholder {name}")
+	} else if u, err := url.Parse(reportUrlFor(c.ReportUrl, "name", "namespace", "cluster")); err != nil || !u.IsAbs() {
+		addError("reportUrl: '%s' is not an absolute URL", c.ReportUrl)
+	}
+
+	if c.Auth.Oidc.Issuer != "" && c.Auth.Oidc.Jwks == "" {
+		addError("auth.oidc: jwks must be set along with issuer")
+	}
+
+	if c.Impersonation.Enabled && !c.Auth.enabled() {
+		addError("impersonation: requires authentication to be configured")
+	}
+
+	// without prefixes, callers could name themselves after users and groups of the cluster, e.g. in an OIDC claim
+	if c.Impersonation.Enabled && (c.Impersonation.UserPrefix == "" || c.Impersonation.GroupPrefix == "") {
+		addError("impersonation: userPrefix and groupPrefix must be set")
+	}
+
+	if c.Matrix.Server != "" && (c.Matrix.User == "" || c.Matrix.AccessToken == "") {
+		addError("matrix: user and accessToken must be set along with server")
+	}
+
+	if c.Slack.Enabled && (c.Slack.Token == "" || c.Slack.ChannelId == "") {
+		addError("slack: token and channelId must be set if the Slack bot is enabled")
+	}
+
+	switch c.Tracing.Exporter {
+	case "", "otlp", "stdout":
+	case "file":
+		if c.Tracing.File == "" {
+			addError("tracing: file must be set for the file exporter")
+		}
+	default:
+		addError("tracing.exporter: must be otlp, stdout or file, got '%s'", c.Tracing.Exporter)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		addError("logLevel: must be debug, info, warn or error, got '%s'", c.LogLevel)
+	}
+
+	return errors.Join(errs...)
+}
+
+// enabled returns whether any means of authentication is configured
+func (ac authConfig) enabled() bool {
+	return ac.ApiKeysFile != "" || ac.ApiKeysSecret != "" || ac.TokenReview || ac.Oidc.Issuer != ""
+}
+
+// clusterConfigs returns the configured clusters or, if there are none, a single cluster named "default" reached with
+// the configured kubeconfig and context
+func (c config) clusterConfigs() []clusterConfig {
+	if len(c.Clusters) > 0 {
+		return c.Clusters
+	}
+	return []clusterConfig{{Name: "default", Kubeconfig: c.Kubeconfig, Context: c.Context}}
+}
+
+// reportUrl fills in the placeholders of a report URL template
+func reportUrlFor(template, name, namespace, cluster string) string {
+	return strings.NewReplacer("{name}", name, "{namespace}", namespace, "{cluster}", cluster).Replace(template)
+}
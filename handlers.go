@@ -17,65 +17,157 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	ortv1 "github.com/haikoschol/ort-operator-api/api/v1"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-func handleRuns(w http.ResponseWriter, r *http.Request) {
+// followLogsInterval is how often the pods of a stage are checked for new ones while following its logs. They are
+// read from the informer cache, unless the controller impersonates the caller.
+const followLogsInterval = 2 * time.Second
+
+// api holds the HTTP handlers. They check the permissions of the caller against the policy and talk to Kubernetes
+// through oc, which withController sets to the controller of the cluster selected by the request. Streams of events and
+// logs end when shutdown is closed.
+type api struct {
+	clusters      clusters
+	oc            ortController
+	policy        *atomic.Pointer[policy]
+	impersonation *impersonation
+	shutdown      <-chan struct{}
+}
+
+// withController wraps a handler so that it gets the ortController for the cluster selected with the query parameter
+// "cluster". If impersonation is set, the controller acts as the caller of the request. CORS preflight requests are
+// passed on as they are, since they carry no credentials.
+func (a api) withController(handler func(api, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			handler(a, w, r)
+			return
+		}
+
+		oc, err := a.clusters.controller(r.URL.Query().Get("cluster"))
+		if err != nil {
+			writeError(w, err, allowedMethods(r.URL.Path))
+			return
+		}
+
+		if a.impersonation != nil {
+			if oc, err = a.impersonation.impersonate(oc, r); err != nil {
+				logError(loggerFrom(r.Context()), "failed to impersonate the caller", err)
+				writeError(w, err, allowedMethods(r.URL.Path))
+				return
+			}
+		}
+
+		caller := a
+		caller.oc = oc
+		handler(caller, w, r)
+	}
+}
+
+// allowedMethods returns the methods accepted by the endpoint serving the path, for responses written before the
+// request reaches the handler of the endpoint
+func allowedMethods(path string) string {
+	if strings.HasPrefix(path, "/logs/") {
+		return "GET"
+	}
+
+	runPath, found := strings.CutPrefix(path, "/runs/")
+	if !found {
+		return "GET,POST"
+	}
+
+	if runPath == reservedRunName {
+		return "GET"
+	}
+
+	if _, action, found := strings.Cut(runPath, "/"); found {
+		if action == "events" {
+			return "GET"
+		}
+		return "POST"
+	}
+
+	return "GET,DELETE"
+}
+
+func (a api) handleRuns(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
 		handleCorsRequest(w, "GET,POST")
 		return
 	}
 
 	if r.Method != http.MethodPost && r.Method != http.MethodGet {
-		writeStatus(w, http.StatusMethodNotAllowed, "GET,POST")
-		return
-	}
-
-	oc, err := newOrtController()
-	if err != nil {
-		log.Printf("handleRuns: %v\n", err)
-		writeStatus(w, http.StatusInternalServerError, "GET,POST")
+		writeProblem(w, http.StatusMethodNotAllowed, "", "GET,POST")
 		return
 	}
 
 	if r.Method == http.MethodPost {
-		handleCreateRun(w, r, oc)
+		a.handleCreateRun(w, r)
 		return
 	}
 
 	if r.Method == http.MethodGet {
-		handleListRuns(w, oc)
+		a.handleListRuns(w, r)
 		return
 	}
 }
 
-func handleCreateRun(w http.ResponseWriter, r *http.Request, oc ortController) {
+func (a api) handleCreateRun(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, permCreate, "GET,POST") {
+		return
+	}
+
 	defer r.Body.Close()
 	decoder := json.NewDecoder(r.Body)
 
-	payload := struct {
-		RepoUrl string `json:"repoUrl"`
-	}{}
+	payload := CreateRunRequest{}
 
 	if err := decoder.Decode(&payload); err != nil {
-		writeStatus(w, http.StatusBadRequest, "GET,POST")
+		writeProblem(w, http.StatusBadRequest, fmt.Sprintf("invalid payload: %v", err), "GET,POST")
+		return
+	}
+
+	if errs := payload.validate(); len(errs) > 0 {
+		writeProblem(w, http.StatusUnprocessableEntity, errs.ToAggregate().Error(), "GET,POST")
 		return
 	}
 
-	created, err := oc.createRun(payload.RepoUrl)
+	logger := loggerFrom(r.Context())
+
+	created, err := a.oc.createRun(r.Context(), r.URL.Query().Get("namespace"), payload, callerName(r))
 	if err != nil {
-		log.Printf("handleCreateRun: oc.createRun: %v\n", err)
-		writeStatus(w, http.StatusInternalServerError, "GET,POST")
+		logError(logger, "failed to create run", err)
+		writeError(w, err, "GET,POST")
 		return
 	}
 
-	ortRun, err := unstructuredToOrtRun(created, true)
+	logger = logger.With("run", created.GetName())
+	logger.Info("created run")
+
+	ortRun, err := a.oc.ortRun(r.Context(), created, true)
 	if err != nil {
-		log.Printf("handleCreateRun: unstructuredToOrtRun: %v\n", err)
-		writeStatus(w, http.StatusInternalServerError, "GET,POST")
+		logError(logger, "failed to convert run", err)
+		writeError(w, err, "GET,POST")
 		return
 	}
 
@@ -85,22 +177,54 @@ func handleCreateRun(w http.ResponseWriter, r *http.Request, oc ortController) {
 
 	encoder := json.NewEncoder(w)
 	if err := encoder.Encode(ortRun); err != nil {
-		log.Printf("handleCreateRun: encoder.Encode: %v\n", err)
+		logger.Error("failed to encode response", "error", err)
 	}
 }
 
-func handleListRuns(w http.ResponseWriter, oc ortController) {
-	runs, err := oc.listRuns()
+func (a api) handleListRuns(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r, permRead, "GET") {
+		return
+	}
+
+	query, err := parseRunQuery(r.URL.Query())
 	if err != nil {
-		log.Printf("handleListRuns: oc.listRuns: %v\n", err)
-		writeStatus(w, http.StatusInternalServerError, "GET")
+		writeProblem(w, http.StatusBadRequest, err.Error(), "GET")
 		return
 	}
 
-	ortRuns, err := unstructuredListToOrtRunList(runs)
+	namespace := r.URL.Query().Get("namespace")
+	allNamespaces := r.URL.Query().Get("allNamespaces") == "true"
+
+	if allNamespaces && (namespace != "" || query.paginated()) {
+		detail := "allNamespaces can not be combined with namespace, limit or continue"
+		writeProblem(w, http.StatusBadRequest, detail, "GET")
+		return
+	}
+
+	logger := loggerFrom(r.Context())
+	var runs *ortv1.OrtRunList
+
+	switch {
+	case allNamespaces:
+		runs, err = a.oc.listRunsInAllNamespaces(r.Context(), query.selector)
+	case query.paginated():
+		runs, err = a.oc.listRunsPage(r.Context(), namespace, query.selector, query.limit, query.continueToken)
+	default:
+		runs, err = a.oc.listRuns(r.Context(), namespace, query.selector)
+	}
+
 	if err != nil {
-		log.Printf("handleListRuns: unstructuredListToOrtRunList: %v\n", err)
-		writeStatus(w, http.StatusInternalServerError, "GET")
+		logError(logger, "failed to list runs", err)
+		writeError(w, err, "GET")
+		return
+	}
+
+	runs.Items = query.apply(runs.Items)
+
+	ortRuns, err := a.oc.ortRunList(r.Context(), runs)
+	if err != nil {
+		logError(logger, "failed to convert runs", err)
+		writeError(w, err, "GET")
 		return
 	}
 
@@ -109,101 +233,405 @@ func handleListRuns(w http.ResponseWriter, oc ortController) {
 
 	encoder := json.NewEncoder(w)
 	if err := encoder.Encode(ortRuns); err != nil {
-		log.Printf("handleCreateRun: encoder.Encode: %v\n", err)
+		logger.Error("failed to encode response", "error", err)
 	}
 }
 
-func handleGetRun(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodOptions {
-		handleCorsRequest(w, "GET")
+func (a api) handleRun(w http.ResponseWriter, r *http.Request) {
+	path, _ := strings.CutPrefix(r.URL.Path, "/runs/")
+	if path == reservedRunName {
+		a.handleRunEvents(w, r, "")
 		return
 	}
 
-	if r.Method != http.MethodGet {
-		writeStatus(w, http.StatusMethodNotAllowed, "GET")
+	if name, action, found := strings.Cut(path, "/"); found {
+		if action == "events" && name != "" {
+			a.handleRunEvents(w, r, name)
+			return
+		}
+
+		a.handleRunAction(w, r, name, action)
 		return
 	}
 
-	oc, err := newOrtController()
-	if err != nil {
-		log.Printf("handleGetRun: newOrtController: %v\n", err)
-		writeStatus(w, http.StatusInternalServerError, "GET")
+	if r.Method == http.MethodOptions {
+		handleCorsRequest(w, "GET,DELETE")
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		writeProblem(w, http.StatusMethodNotAllowed, "", "GET,DELETE")
 		return
 	}
 
 	name, found := strings.CutPrefix(r.URL.Path, "/runs/")
 	if !found || name == "" {
-		writeStatus(w, http.StatusBadRequest, "GET")
+		writeProblem(w, http.StatusBadRequest, "missing run name", "GET,DELETE")
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		a.handleDeleteRun(w, r, name)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		a.handleGetRun(w, r, name)
+		return
+	}
+}
+
+func (a api) handleGetRun(w http.ResponseWriter, r *http.Request, name string) {
+	if !a.authorize(w, r, permRead, "GET,DELETE") {
 		return
 	}
 
-	run, err := oc.getRun(name)
+	logger := loggerFrom(r.Context()).With("run", name)
+
+	run, err := a.oc.getRun(r.Context(), r.URL.Query().Get("namespace"), name)
 	if err != nil {
-		log.Printf("handleGetRun: oc.getRun: %v\n", err)
-		writeStatus(w, http.StatusInternalServerError, "GET")
+		logError(logger, "failed to get run", err)
+		writeError(w, err, "GET,DELETE")
 		return
 	}
 
-	ortRun, err := unstructuredToOrtRun(run, true)
+	ortRun, err := a.oc.ortRun(r.Context(), run, true)
 	if err != nil {
-		log.Printf("handleGetRun: unstructuredToOrtRun: %v\n", err)
-		writeStatus(w, http.StatusInternalServerError, "GET")
+		logError(logger, "failed to convert run", err)
+		writeError(w, err, "GET,DELETE")
 		return
 	}
 
-	writeCorsHeaders(w, "GET")
+	writeCorsHeaders(w, "GET,DELETE")
 	w.Header().Add("Content-Type", "application/json")
 
 	encoder := json.NewEncoder(w)
 	if err := encoder.Encode(ortRun); err != nil {
-		log.Printf("handleGetRun: encoder.Encode: %v\n", err)
+		logger.Error("failed to encode response", "error", err)
+	}
+}
+
+// handleDeleteRun deletes the OrtRun with the given name. The query parameter "propagationPolicy" controls whether the
+// Jobs and pods of the analyzer, scanner and reporter stages are deleted along with it and defaults to "Background".
+func (a api) handleDeleteRun(w http.ResponseWriter, r *http.Request, name string) {
+	if !a.authorize(w, r, permDelete, "GET,DELETE") {
+		return
+	}
+
+	propagation, err := parsePropagationPolicy(r.URL.Query().Get("propagationPolicy"))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error(), "GET,DELETE")
+		return
+	}
+
+	logger := loggerFrom(r.Context()).With("run", name)
+
+	if err := a.oc.deleteRun(r.Context(), r.URL.Query().Get("namespace"), name, propagation); err != nil {
+		logError(logger, "failed to delete run", err)
+		writeError(w, err, "GET,DELETE")
+		return
+	}
+
+	logger.Info("deleted run")
+
+	writeStatus(w, http.StatusNoContent, "GET,DELETE")
+}
+
+// handleRunAction handles requests to sub-resources of an OrtRun that trigger an action, like /runs/<name>/abort
+func (a api) handleRunAction(w http.ResponseWriter, r *http.Request, name, action string) {
+	if r.Method == http.MethodOptions {
+		handleCorsRequest(w, "POST")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeProblem(w, http.StatusMethodNotAllowed, "", "POST")
+		return
+	}
+
+	if name == "" {
+		writeProblem(w, http.StatusBadRequest, "missing run name", "POST")
+		return
+	}
+
+	switch action {
+	case "abort":
+		a.handleAbortRun(w, r, name)
+	case "rerun":
+		a.handleRerunRun(w, r, name)
+	default:
+		writeProblem(w, http.StatusNotFound, fmt.Sprintf("unknown action '%s'", action), "POST")
+	}
+}
+
+func (a api) handleAbortRun(w http.ResponseWriter, r *http.Request, name string) {
+	if !a.authorize(w, r, permAbort, "POST") {
+		return
+	}
+
+	logger := loggerFrom(r.Context()).With("run", name)
+
+	aborted, err := a.oc.abortRun(r.Context(), r.URL.Query().Get("namespace"), name)
+	if err != nil {
+		logError(logger, "failed to abort run", err)
+		writeError(w, err, "POST")
+		return
+	}
+
+	logger.Info("aborted run")
+
+	ortRun, err := a.oc.ortRun(r.Context(), aborted, true)
+	if err != nil {
+		logError(logger, "failed to convert run", err)
+		writeError(w, err, "POST")
+		return
+	}
+
+	writeCorsHeaders(w, "POST")
+	w.Header().Add("Content-Type", "application/json")
+
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(ortRun); err != nil {
+		logger.Error("failed to encode response", "error", err)
+	}
+}
+
+// handleRerunRun creates a new OrtRun from the spec of an existing one. The optional query parameter "stage" selects
+// the stage to restart from and reuses the results of the preceding stages of the existing run.
+func (a api) handleRerunRun(w http.ResponseWriter, r *http.Request, name string) {
+	if !a.authorize(w, r, permCreate, "POST") {
+		return
+	}
+
+	stage := r.URL.Query().Get("stage")
+	if stage != "" && !isStage(stage) {
+		writeProblem(w, http.StatusBadRequest, fmt.Sprintf("unknown stage '%s'", stage), "POST")
+		return
+	}
+
+	logger := loggerFrom(r.Context()).With("run", name)
+	if stage != "" {
+		logger = logger.With("stage", stage)
+	}
+
+	created, err := a.oc.rerunRun(r.Context(), r.URL.Query().Get("namespace"), name, stage, callerName(r))
+	if err != nil {
+		logError(logger, "failed to rerun run", err)
+		writeError(w, err, "POST")
+		return
+	}
+
+	logger = logger.With("new_run", created.GetName())
+	logger.Info("reran run")
+
+	ortRun, err := a.oc.ortRun(r.Context(), created, true)
+	if err != nil {
+		logError(logger, "failed to convert run", err)
+		writeError(w, err, "POST")
+		return
+	}
+
+	writeCorsHeaders(w, "POST")
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(ortRun); err != nil {
+		logger.Error("failed to encode response", "error", err)
+	}
+}
+
+// handleRunEvents streams OrtRuns as Server-Sent Events whenever the status of one of their stages changes. If name is
+// empty, all OrtRuns are watched. The id of each event is the resourceVersion of the OrtRun, so clients that reconnect
+// with the Last-Event-ID header only receive the changes they missed.
+func (a api) handleRunEvents(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method == http.MethodOptions {
+		handleCorsRequest(w, "GET")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		writeProblem(w, http.StatusMethodNotAllowed, "", "GET")
+		return
+	}
+
+	if !a.authorize(w, r, permRead, "GET") {
+		return
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+	resourceVersion := r.Header.Get("Last-Event-ID")
+
+	logger := loggerFrom(r.Context())
+	if name != "" {
+		logger = logger.With("run", name)
+	}
+
+	ctx, cancel := a.streamContext(r)
+	defer cancel()
+
+	watcher, err := a.oc.watchRuns(ctx, namespace, name, resourceVersion)
+	if err != nil {
+		logError(logger, "failed to watch runs", err)
+		writeError(w, err, "GET")
+		return
+	}
+
+	writeCorsHeaders(w, "GET")
+
+	stream, err := newEventStream(w)
+	if err != nil {
+		watcher.Stop()
+		logger.Error("failed to start event stream", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	statuses := map[string]RunStatus{}
+
+	for {
+		resourceVersion, err = a.sendRunEvents(ctx, stream, watcher, statuses, resourceVersion)
+		watcher.Stop()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			if !apierrors.IsResourceExpired(err) && !apierrors.IsGone(err) {
+				logError(logger, "failed to send events", err)
+				return
+			}
+
+			// the client fell too far behind, so start over by sending the current state of all runs
+			resourceVersion = ""
+		}
+
+		// the API server closes watches after a while or the client is out of date, so keep going
+		watcher, err = a.oc.watchRuns(ctx, namespace, name, resourceVersion)
+		if err != nil {
+			logError(logger, "failed to watch runs", err)
+			return
+		}
+	}
+}
+
+// sendRunEvents sends an event for each change in the status of an OrtRun received from watcher until the watch ends.
+// It returns the last resourceVersion it has seen.
+func (a api) sendRunEvents(
+	ctx context.Context,
+	stream eventStream,
+	watcher watch.Interface,
+	statuses map[string]RunStatus,
+	resourceVersion string,
+) (string, error) {
+	for event := range watcher.ResultChan() {
+		if event.Type == watch.Error {
+			return resourceVersion, apierrors.FromObject(event.Object)
+		}
+
+		run, ok := event.Object.(*ortv1.OrtRun)
+		if !ok {
+			continue
+		}
+
+		resourceVersion = run.GetResourceVersion()
+		if event.Type == watch.Bookmark {
+			continue
+		}
+
+		status := runStatusFromResource(run)
+		previous, seen := statuses[run.GetName()]
+
+		if event.Type == watch.Deleted {
+			delete(statuses, run.GetName())
+		} else if seen && previous == status {
+			continue
+		} else {
+			statuses[run.GetName()] = status
+		}
+
+		ortRun, err := a.oc.ortRun(ctx, run, false)
+		if err != nil {
+			return resourceVersion, err
+		}
+
+		if err := stream.send(strings.ToLower(string(event.Type)), resourceVersion, ortRun); err != nil {
+			return resourceVersion, err
+		}
 	}
+
+	return resourceVersion, nil
 }
 
-func handleLogs(w http.ResponseWriter, r *http.Request) {
+func (a api) handleLogs(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
 		handleCorsRequest(w, "GET")
 		return
 	}
 
 	if r.Method != http.MethodGet {
-		writeStatus(w, http.StatusMethodNotAllowed, "GET")
+		writeProblem(w, http.StatusMethodNotAllowed, "", "GET")
+		return
+	}
+
+	if !a.authorize(w, r, permRead, "GET") {
 		return
 	}
 
 	path, found := strings.CutPrefix(r.URL.Path, "/logs/")
 	if !found {
-		writeStatus(w, http.StatusBadRequest, "GET")
+		writeProblem(w, http.StatusBadRequest, "missing run name and stage", "GET")
 		return
 	}
 
 	name, stage, found := strings.Cut(path, "/")
 	if !found {
-		writeStatus(w, http.StatusBadRequest, "GET")
+		writeProblem(w, http.StatusBadRequest, "missing stage", "GET")
 		return
 	}
 
-	oc, err := newOrtController()
+	if name == "" {
+		writeProblem(w, http.StatusBadRequest, "missing run name", "GET")
+		return
+	}
+
+	// the status of an unknown stage is never final, so following its logs would never end
+	if !isStage(stage) {
+		writeProblem(w, http.StatusBadRequest, fmt.Sprintf("unknown stage '%s'", stage), "GET")
+		return
+	}
+
+	trace.SpanFromContext(r.Context()).SetAttributes(attrRunName.String(name), attrStage.String(stage))
+	logger := loggerFrom(r.Context()).With("run", name, "stage", stage)
+
+	namespace := r.URL.Query().Get("namespace")
+
+	opts, err := parseLogOptions(r.URL.Query())
 	if err != nil {
-		log.Printf("handleLogs: newOrtController: %v\n", err)
-		writeStatus(w, http.StatusInternalServerError, "GET")
+		writeProblem(w, http.StatusBadRequest, err.Error(), "GET")
+		return
+	}
+
+	if r.URL.Query().Get("follow") == "true" {
+		a.handleFollowLogs(w, r.WithContext(withLogger(r.Context(), logger)), namespace, name, stage, opts)
 		return
 	}
 
-	pods, err := oc.listPods(name, stage)
+	pods, err := a.oc.listPods(r.Context(), namespace, name, stage)
 	if err != nil {
-		log.Printf("handleLogs: oc.listPods: %v\n", err)
-		writeStatus(w, http.StatusInternalServerError, "GET")
+		logError(logger, "failed to list pods", err)
+		writeError(w, err, "GET")
 		return
 	}
 
 	var podLogs []PodLogs
 
 	for _, pod := range pods {
-		logs, err := oc.getLogs(pod.Name)
+		logs, err := a.oc.getLogs(r.Context(), pod.Namespace, pod.Name, opts)
 		if err != nil {
-			log.Printf("handleLogs: oc.getLogs(\"%s\"): %v\n", pod.Name, err)
-			writeStatus(w, http.StatusInternalServerError, "GET")
+			logError(logger.With("pod", pod.Name), "failed to get logs", err)
+			writeError(w, err, "GET")
 			return
 		}
 
@@ -220,7 +648,188 @@ func handleLogs(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 	encoder := json.NewEncoder(w)
 	if err := encoder.Encode(ortLogs); err != nil {
-		log.Printf("handleLogs: encoder.Encode: %v\n", err)
+		logger.Error("failed to encode response", "error", err)
+	}
+}
+
+// handleFollowLogs streams the logs of the pods of a stage as Server-Sent Events until the stage has finished, the
+// client goes away or the server shuts down. The logs of a pod are followed once its containers have started, so the
+// stream waits for stages that have not started yet and picks up the pods the Job creates to retry a stage. Each "log"
+// event carries a LogLine and the stream ends with an "end" event once the stage has finished and the logs of all its
+// pods have been sent.
+func (a api) handleFollowLogs(
+	w http.ResponseWriter,
+	r *http.Request,
+	namespace string,
+	name string,
+	stage string,
+	opts v1.PodLogOptions,
+) {
+	logger := loggerFrom(r.Context())
+
+	// a missing run is reported before the stream starts
+	if _, err := a.oc.getRun(r.Context(), namespace, name); err != nil {
+		logError(logger, "failed to get run", err)
+		writeError(w, err, "GET")
+		return
+	}
+
+	writeCorsHeaders(w, "GET")
+
+	stream, err := newEventStream(w)
+	if err != nil {
+		logger.Error("failed to start event stream", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := a.streamContext(r)
+	defer cancel()
+
+	lines := make(chan LogLine)
+	followed := map[string]bool{}
+	var wg sync.WaitGroup
+
+	// followNewPods starts following the pods of the stage whose containers have started since the last call and
+	// returns whether the stage has finished
+	followNewPods := func() bool {
+		// the run is read before the pods, so once the stage has finished, the list has all pods it ever had
+		run, err := a.oc.getRun(ctx, namespace, name)
+		if apierrors.IsNotFound(err) {
+			return true
+		}
+		if err != nil {
+			logError(logger, "failed to get run", err)
+			return false
+		}
+
+		pods, err := a.oc.listPods(ctx, namespace, name, stage)
+		if err != nil {
+			logError(logger, "failed to list pods", err)
+			return false
+		}
+
+		for _, pod := range pods {
+			if followed[pod.Name] || !podStarted(pod) {
+				continue
+			}
+
+			followed[pod.Name] = true
+			wg.Add(1)
+			go func(namespace, podName string) {
+				defer wg.Done()
+				a.followPodLogs(ctx, namespace, podName, opts, lines)
+			}(pod.Namespace, pod.Name)
+		}
+
+		status := runStatusFromResource(run).byStage()[stage]
+		return status == Succeeded || status == Failed || status == Aborted
+	}
+
+	ticker := time.NewTicker(followLogsInterval)
+	defer ticker.Stop()
+
+	// stays nil until the stage has finished, then is closed once all pods are followed to the end
+	var followersDone chan struct{}
+
+	startFollowers := func() {
+		if followersDone == nil && followNewPods() {
+			followersDone = make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(followersDone)
+			}()
+		}
+	}
+
+	startFollowers()
+
+	for {
+		select {
+		case line := <-lines:
+			if err := stream.send("log", "", line); err != nil {
+				logger.Error("failed to send log line", "error", err)
+				return
+			}
+		case <-ticker.C:
+			startFollowers()
+		case <-followersDone:
+			if err := stream.send("end", "", struct{}{}); err != nil {
+				logger.Error("failed to send end of logs", "error", err)
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// podStarted returns whether the containers of the pod have started, so its logs can be read
+func podStarted(pod v1.Pod) bool {
+	return pod.Status.Phase == v1.PodRunning || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
+
+// streamContext returns the context of a long-running stream, which ends with the request or when the server shuts down
+func (a api) streamContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(r.Context())
+
+	go func() {
+		select {
+		case <-a.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+func (a api) followPodLogs(
+	ctx context.Context,
+	namespace string,
+	podName string,
+	opts v1.PodLogOptions,
+	lines chan<- LogLine,
+) {
+	// the span lasts as long as the logs are streamed
+	ctx, span := tracer.Start(
+		ctx,
+		"followPodLogs",
+		trace.WithAttributes(semconv.K8SNamespaceName(namespace), semconv.K8SPodName(podName)),
+	)
+	defer span.End()
+
+	send := func(line LogLine) bool {
+		select {
+		case lines <- line:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	logs, err := a.oc.streamLogs(ctx, namespace, podName, opts)
+	if err != nil {
+		logError(loggerFrom(ctx).With("pod", podName), "failed to stream logs", err)
+		span.RecordError(err)
+		send(LogLine{PodName: podName, Error: err.Error()})
+		return
+	}
+	defer logs.Close()
+
+	scanner := bufio.NewScanner(logs)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		if !send(LogLine{PodName: podName, Line: scanner.Text()}) {
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		loggerFrom(ctx).Error("failed to read logs", "pod", podName, "error", err)
+		span.RecordError(err)
+		send(LogLine{PodName: podName, Error: err.Error()})
 	}
 }
 
@@ -229,6 +838,86 @@ func writeStatus(w http.ResponseWriter, status int, allowedMethods string) {
 	w.WriteHeader(status)
 }
 
+// writeError maps err to an HTTP status code and writes it together with a problem details body. The details of
+// unexpected errors are not disclosed to the client.
+func writeError(w http.ResponseWriter, err error, allowedMethods string) {
+	status := statusForError(err)
+
+	p := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Reason: string(apierrors.ReasonForError(err)),
+	}
+
+	if status != http.StatusInternalServerError {
+		p.Detail = err.Error()
+	}
+
+	sendProblem(w, p, allowedMethods)
+}
+
+// writeProblem writes the status code and a problem details body with the given detail message
+func writeProblem(w http.ResponseWriter, status int, detail string, allowedMethods string) {
+	p := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+
+	sendProblem(w, p, allowedMethods)
+}
+
+func sendProblem(w http.ResponseWriter, p Problem, allowedMethods string) {
+	writeCorsHeaders(w, allowedMethods)
+	w.Header().Add("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(p); err != nil {
+		slog.Error("failed to encode problem", "error", err)
+	}
+}
+
+// logError logs an error of a handler at level error or, if the client is to blame for it, at level warn
+func logError(logger *slog.Logger, msg string, err error) {
+	level := slog.LevelError
+	if statusForError(err) < http.StatusInternalServerError {
+		level = slog.LevelWarn
+	}
+	logger.Log(context.Background(), level, msg, "error", err)
+}
+
+// statusForError maps errors returned by the Kubernetes API and ortController to HTTP status codes
+func statusForError(err error) int {
+	switch {
+	case apierrors.IsNotFound(err):
+		return http.StatusNotFound
+	case apierrors.IsAlreadyExists(err), apierrors.IsConflict(err), errors.Is(err, errPrerequisiteStageFailed),
+		errors.Is(err, errRunFinished):
+		return http.StatusConflict
+	case apierrors.IsForbidden(err), errors.Is(err, errNamespaceNotAllowed), errors.Is(err, errReservedName):
+		return http.StatusForbidden
+	case apierrors.IsUnauthorized(err), errors.Is(err, errUnauthenticatedCaller):
+		return http.StatusUnauthorized
+	case errors.Is(err, errUnknownCluster), apierrors.IsBadRequest(err):
+		// bad requests to the Kubernetes API are e.g. malformed continue tokens or label selectors
+		return http.StatusBadRequest
+	case apierrors.IsInvalid(err):
+		return http.StatusUnprocessableEntity
+	case apierrors.IsResourceExpired(err), apierrors.IsGone(err):
+		return http.StatusGone
+	case apierrors.IsTooManyRequests(err):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errClusterNotReady):
+		return http.StatusServiceUnavailable
+	case apierrors.IsTimeout(err), apierrors.IsServerTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
 func handleCorsRequest(w http.ResponseWriter, allowedMethods string) {
 	writeStatus(w, http.StatusNoContent, allowedMethods)
 }
@@ -236,4 +925,70 @@ func handleCorsRequest(w http.ResponseWriter, allowedMethods string) {
 func writeCorsHeaders(w http.ResponseWriter, allowedMethods string) {
 	w.Header().Add("Access-Control-Allow-Origin", "*")
 	w.Header().Add("Access-Control-Allow-Methods", allowedMethods)
+	w.Header().Add("Access-Control-Allow-Headers", "Authorization, Content-Type, Last-Event-ID, X-Request-Id")
+	w.Header().Add("Access-Control-Expose-Headers", "X-Request-Id")
+}
+
+func parsePropagationPolicy(value string) (metav1.DeletionPropagation, error) {
+	switch metav1.DeletionPropagation(value) {
+	case "":
+		return metav1.DeletePropagationBackground, nil
+	case metav1.DeletePropagationForeground, metav1.DeletePropagationBackground, metav1.DeletePropagationOrphan:
+		return metav1.DeletionPropagation(value), nil
+	}
+	return "", fmt.Errorf("invalid propagation policy '%s'", value)
+}
+
+// parseLogOptions builds the options for fetching pod logs from the query parameters "tailLines", "sinceSeconds",
+// "sinceTime" (RFC 3339), "container", "previous" and "timestamps"
+func parseLogOptions(query url.Values) (v1.PodLogOptions, error) {
+	opts := v1.PodLogOptions{
+		Container: query.Get("container"),
+	}
+
+	if value := query.Get("tailLines"); value != "" {
+		tailLines, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || tailLines < 0 {
+			return opts, fmt.Errorf("invalid value for tailLines: '%s'", value)
+		}
+		opts.TailLines = &tailLines
+	}
+
+	if query.Get("sinceSeconds") != "" && query.Get("sinceTime") != "" {
+		return opts, errors.New("only one of sinceSeconds and sinceTime may be specified")
+	}
+
+	if value := query.Get("sinceSeconds"); value != "" {
+		sinceSeconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || sinceSeconds < 1 {
+			return opts, fmt.Errorf("invalid value for sinceSeconds: '%s'", value)
+		}
+		opts.SinceSeconds = &sinceSeconds
+	}
+
+	if value := query.Get("sinceTime"); value != "" {
+		sinceTime, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid value for sinceTime: '%s'", value)
+		}
+		opts.SinceTime = &metav1.Time{Time: sinceTime}
+	}
+
+	if value := query.Get("previous"); value != "" {
+		previous, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid value for previous: '%s'", value)
+		}
+		opts.Previous = previous
+	}
+
+	if value := query.Get("timestamps"); value != "" {
+		timestamps, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid value for timestamps: '%s'", value)
+		}
+		opts.Timestamps = timestamps
+	}
+
+	return opts, nil
 }
@@ -0,0 +1,279 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	ortv1 "github.com/haikoschol/ort-operator-api/api/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readyTimeout limits how long the checks of /readyz may take. They run concurrently.
+const readyTimeout = 5 * time.Second
+
+const (
+	healthOk       = "ok"
+	healthDegraded = "degraded"
+	healthFailed   = "failed"
+)
+
+var errBotNotConnected = errors.New("not connected yet")
+
+// readinessCheck is a single named check of /readyz. cluster is the name of the cluster it checks and empty for the
+// checks of bots.
+type readinessCheck struct {
+	name    string
+	check   func(ctx context.Context) error
+	cluster string
+}
+
+// health serves the liveness and readiness probes. Besides the checks of each cluster, /readyz runs those in bots.
+type health struct {
+	clusters clusters
+	bots     []readinessCheck
+}
+
+// handleHealthz reports that the process is alive. It does not check any dependencies, so a Kubernetes outage does not
+// get the API restarted.
+func (h health) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeProblem(w, http.StatusMethodNotAllowed, "", "GET")
+		return
+	}
+
+	writeHealthReport(w, http.StatusOK, HealthReport{Status: healthOk})
+}
+
+// handleReadyz runs all readiness checks and responds with 503 if no cluster is ready or a bot is not connected. A
+// cluster is ready if all of its checks pass. Since the API keeps serving the clusters that are ready, failed checks of
+// the other clusters only degrade the reported status.
+func (h health) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeProblem(w, http.StatusMethodNotAllowed, "", "GET")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	checks := h.checks()
+	report := HealthReport{Status: healthOk, Checks: make([]HealthCheck, len(checks))}
+	var wg sync.WaitGroup
+
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c readinessCheck) {
+			defer wg.Done()
+
+			report.Checks[i] = HealthCheck{Name: c.name, Status: healthOk}
+			if err := c.check(ctx); err != nil {
+				report.Checks[i] = HealthCheck{Name: c.name, Status: healthFailed, Error: err.Error()}
+			}
+		}(i, c)
+	}
+
+	wg.Wait()
+
+	status := http.StatusOK
+	report.Status = h.readiness(checks, report.Checks)
+	if report.Status == healthFailed {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeHealthReport(w, status, report)
+}
+
+// readiness returns the overall status for the results of the checks: "ok" if all passed, "failed" if no cluster is
+// ready or a bot check failed and "degraded" otherwise
+func (h health) readiness(checks []readinessCheck, results []HealthCheck) string {
+	unready := map[string]bool{}
+	failed := false
+
+	for i, c := range checks {
+		if results[i].Status == healthOk {
+			continue
+		}
+
+		failed = true
+		if c.cluster == "" {
+			return healthFailed
+		}
+		unready[c.cluster] = true
+	}
+
+	switch {
+	case !failed:
+		return healthOk
+	case len(unready) == len(h.clusters.names):
+		return healthFailed
+	default:
+		return healthDegraded
+	}
+}
+
+func writeHealthReport(w http.ResponseWriter, status int, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(report); err != nil {
+		slog.Error("failed to encode health report", "error", err)
+	}
+}
+
+// checks returns the checks of all clusters and bots. For each cluster, the API server must be reachable, the OrtRun
+// resource must be served, the caches must be filled and the API must be allowed to list OrtRuns and pods in every
+// allowed namespace.
+func (h health) checks() []readinessCheck {
+	var checks []readinessCheck
+
+	for _, name := range h.clusters.names {
+		oc := h.clusters.controllers[name]
+		prefix := fmt.Sprintf("cluster/%s/", name)
+
+		checks = append(checks,
+			readinessCheck{prefix + "apiserver", oc.checkApiServer, name},
+			readinessCheck{prefix + "discovery", oc.checkOrtRunResource, name},
+			readinessCheck{prefix + "caches", oc.checkCachesSynced, name},
+		)
+
+		resources := []schema.GroupResource{ortv1.Resource.GroupResource(), {Resource: "pods"}}
+
+		for _, ns := range oc.namespaces.list() {
+			for _, resource := range resources {
+				ns, resource := ns, resource
+				checks = append(checks, readinessCheck{
+					fmt.Sprintf("%snamespace/%s/list-%s", prefix, ns, resource.Resource),
+					func(ctx context.Context) error { return oc.checkCanList(ctx, ns, resource) },
+					name,
+				})
+			}
+		}
+	}
+
+	return append(checks, h.bots...)
+}
+
+// checkApiServer checks that the API server of the cluster is reachable and answers requests
+func (oc ortController) checkApiServer(ctx context.Context) error {
+	return oc.clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+}
+
+// checkOrtRunResource checks that the API server serves the OrtRun resource, i.e. that its CRD is installed
+func (oc ortController) checkOrtRunResource(ctx context.Context) error {
+	// the discovery client takes no context, so the deadline is applied to its config instead
+	config := rest.CopyConfig(oc.config)
+	if deadline, ok := ctx.Deadline(); ok {
+		config.Timeout = time.Until(deadline)
+	}
+
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	groupVersion := ortv1.Resource.GroupVersion().String()
+
+	resources, err := dc.ServerResourcesForGroupVersion(groupVersion)
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range resources.APIResources {
+		if resource.Name == ortv1.Resource.Resource {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("resource '%s' is not served in '%s'", ortv1.Resource.Resource, groupVersion)
+}
+
+// checkCachesSynced checks that the caches of OrtRuns and pods are filled, which they might not be if the cluster could
+// not be reached on startup
+func (oc ortController) checkCachesSynced(context.Context) error {
+	if !oc.cachesSynced() {
+		return errClusterNotReady
+	}
+	return nil
+}
+
+// checkCanList checks with a SelfSubjectAccessReview that the API may list the resource in the namespace, which the
+// informers need
+func (oc ortController) checkCanList(ctx context.Context, namespace string, resource schema.GroupResource) error {
+	review := &authzv1.SelfSubjectAccessReview{
+		Spec: authzv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authzv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "list",
+				Group:     resource.Group,
+				Resource:  resource.Resource,
+			},
+		},
+	}
+
+	review, err := oc.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+
+	if !review.Status.Allowed {
+		if review.Status.Reason != "" {
+			return fmt.Errorf("not allowed to list %s: %s", resource, review.Status.Reason)
+		}
+		return fmt.Errorf("not allowed to list %s", resource)
+	}
+
+	return nil
+}
+
+// botStatus tracks whether a bot is connected to its chat platform. It is shared by all copies of a bot.
+type botStatus struct {
+	mu        sync.Mutex
+	connected bool
+	err       error
+}
+
+// set records the outcome of the last attempt of the bot to talk to its platform
+func (bs *botStatus) set(err error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	bs.connected = err == nil
+	bs.err = err
+}
+
+func (bs *botStatus) check(context.Context) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if !bs.connected && bs.err == nil {
+		return errBotNotConnected
+	}
+	return bs.err
+}
@@ -0,0 +1,77 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// reservedPrefix starts the names of the users and groups Kubernetes itself defines, like system:masters
+const reservedPrefix = "system:"
+
+var (
+	errUnauthenticatedCaller = errors.New("impersonation requires an authenticated caller")
+	errReservedName          = fmt.Errorf("names and groups starting with '%s' can not be impersonated", reservedPrefix)
+)
+
+// impersonation maps authenticated callers to Kubernetes users and groups, e.g. "alice" to "oidc:alice". Callers
+// authenticated with a TokenReview already are Kubernetes users and are impersonated as they are. Other callers must
+// not end up as users or groups reserved by Kubernetes, or a groups claim with system:masters would grant full access
+// to the cluster.
+type impersonation struct {
+	userPrefix  string
+	groupPrefix string
+}
+
+func (imp impersonation) kubernetesUser(id identity) (string, []string, error) {
+	if id.kubernetes {
+		return id.name, id.groups, nil
+	}
+
+	user := imp.userPrefix + id.name
+	if strings.HasPrefix(user, reservedPrefix) {
+		return "", nil, fmt.Errorf("%w: user '%s'", errReservedName, user)
+	}
+
+	groups := make([]string, 0, len(id.groups))
+	for _, group := range id.groups {
+		group = imp.groupPrefix + group
+		if strings.HasPrefix(group, reservedPrefix) {
+			return "", nil, fmt.Errorf("%w: group '%s'", errReservedName, group)
+		}
+		groups = append(groups, group)
+	}
+
+	return user, groups, nil
+}
+
+// impersonate returns a controller that acts as the caller of the request
+func (imp impersonation) impersonate(oc ortController, r *http.Request) (ortController, error) {
+	id, ok := identityFromContext(r.Context())
+	if !ok {
+		return oc, errUnauthenticatedCaller
+	}
+
+	user, groups, err := imp.kubernetesUser(id)
+	if err != nil {
+		return oc, err
+	}
+	return oc.impersonate(user, groups)
+}
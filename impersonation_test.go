@@ -0,0 +1,149 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestImpersonationKubernetesUser(t *testing.T) {
+	prefixed := impersonation{userPrefix: "oidc:", groupPrefix: "oidc:"}
+
+	tests := []struct {
+		name       string
+		imp        impersonation
+		id         identity
+		wantUser   string
+		wantGroups []string
+		wantErr    error
+	}{
+		{
+			"API key",
+			prefixed,
+			identity{name: "ci"},
+			"oidc:ci",
+			[]string{},
+			nil,
+		},
+		{
+			"OIDC with groups",
+			prefixed,
+			identity{name: "alice", groups: []string{"developers", "testers"}},
+			"oidc:alice",
+			[]string{"oidc:developers", "oidc:testers"},
+			nil,
+		},
+		{
+			"OIDC claiming a reserved group",
+			prefixed,
+			identity{name: "mallory", groups: []string{"system:masters"}},
+			"oidc:mallory",
+			[]string{"oidc:system:masters"},
+			nil,
+		},
+		{
+			"TokenReview",
+			prefixed,
+			identity{name: "system:serviceaccount:ort:bot", groups: []string{"system:serviceaccounts"}, kubernetes: true},
+			"system:serviceaccount:ort:bot",
+			[]string{"system:serviceaccounts"},
+			nil,
+		},
+		{
+			"unprefixed name",
+			impersonation{},
+			identity{name: "alice", groups: []string{"developers"}},
+			"alice",
+			[]string{"developers"},
+			nil,
+		},
+		{
+			"unprefixed reserved user",
+			impersonation{},
+			identity{name: "system:admin"},
+			"",
+			nil,
+			errReservedName,
+		},
+		{
+			"unprefixed reserved group",
+			impersonation{},
+			identity{name: "mallory", groups: []string{"developers", "system:masters"}},
+			"",
+			nil,
+			errReservedName,
+		},
+		{
+			"prefix that is reserved",
+			impersonation{userPrefix: "system:", groupPrefix: "oidc:"},
+			identity{name: "alice"},
+			"",
+			nil,
+			errReservedName,
+		},
+		{
+			"group prefix that is reserved",
+			impersonation{userPrefix: "oidc:", groupPrefix: "system:"},
+			identity{name: "alice", groups: []string{"masters"}},
+			"",
+			nil,
+			errReservedName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, groups, err := tt.imp.kubernetesUser(tt.id)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("kubernetesUser() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if user != tt.wantUser || !reflect.DeepEqual(groups, tt.wantGroups) {
+				t.Errorf("kubernetesUser() = %q, %v, want %q, %v", user, groups, tt.wantUser, tt.wantGroups)
+			}
+		})
+	}
+}
+
+func TestConfigRequiresImpersonationPrefixes(t *testing.T) {
+	tests := []struct {
+		name        string
+		userPrefix  string
+		groupPrefix string
+		wantErr     bool
+	}{
+		{"both prefixes", "oidc:", "oidc:", false},
+		{"no user prefix", "", "oidc:", true},
+		{"no group prefix", "oidc:", "", true},
+		{"no prefixes", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.Auth.TokenReview = true
+			cfg.Impersonation = impersonationConfig{Enabled: true, UserPrefix: tt.userPrefix, GroupPrefix: tt.groupPrefix}
+
+			if err := cfg.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
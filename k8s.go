@@ -18,36 +18,79 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/cip8/autoname"
+	ortv1 "github.com/haikoschol/ort-operator-api/api/v1"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"io"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
-	"os"
-	"path"
-	"strings"
+	"log/slog"
+	"net/http"
+	"time"
 )
 
-const namespace = "ort"
+var stages = []string{"analyzer", "scanner", "reporter"}
 
-var groupVersionResource = schema.GroupVersionResource{Group: "inocybe.io", Version: "v1", Resource: "ortruns"}
+// jobNameLabel is the label the Job controller sets on the pods of a Job to its name
+const jobNameLabel = "job-name"
 
+// reservedRunName is the path segment of the event stream of all OrtRuns, so OrtRuns with that name could not be read
+// or deleted through the API. The API never gives it to the OrtRuns it creates.
+const reservedRunName = "events"
+
+var (
+	errPrerequisiteStageFailed = errors.New("a preceding stage has not succeeded")
+	errRunFinished             = errors.New("the run has already finished")
+	errNamespaceNotAllowed     = errors.New("namespace is not allowed")
+)
+
+// ortController creates and modifies OrtRuns through the Kubernetes API and serves reads of OrtRuns and pods from
+// informer caches. It is meant to be created once and shared. Controllers impersonating a user have no informer caches.
+//
+// The controller only touches the namespaces in its allowlist. All methods taking a namespace fall back to the default
+// namespace if it is empty. Methods calling the API server take the context of the request or command they serve, so
+// their work stops once the caller goes away.
 type ortController struct {
-	dynClient *dynamic.DynamicClient
-	clientset *kubernetes.Clientset
+	cluster          string
+	config           *rest.Config
+	defaultNamespace string
+	namespaces       *namespaceSet
+	dynClient        dynamic.Interface
+	clientset        *kubernetes.Clientset
 }
 
-func newOrtController() (ortController, error) {
-	var oc ortController
+// newOrtController creates an ortController for the named cluster with the given default namespace and allowlist. The
+// default namespace is always allowed.
+func newOrtController(
+	cluster string,
+	config *rest.Config,
+	defaultNamespace string,
+	allowedNamespaces []string,
+) (ortController, error) {
+	// count, time and trace all requests of the controller, including those of impersonating copies
+	config = rest.CopyConfig(config)
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return kubernetesTransport{cluster, otelhttp.NewTransport(rt)}
+	})
 
-	config, err := loadConfig()
-	if err != nil {
-		return oc, err
+	oc := ortController{
+		cluster:          cluster,
+		config:           config,
+		defaultNamespace: defaultNamespace,
+		namespaces:       newNamespaceSet(),
 	}
 
 	dynClient, err := dynamic.NewForConfig(config)
@@ -62,71 +105,660 @@ func newOrtController() (ortController, error) {
 
 	oc.dynClient = dynClient
 	oc.clientset = clientset
+
+	for _, ns := range append([]string{defaultNamespace}, allowedNamespaces...) {
+		if !oc.isAllowed(ns) {
+			oc.namespaces.add(ns, oc.newNamespaceClients(ns))
+		}
+	}
+
 	return oc, nil
 }
 
-func (oc ortController) listRuns() (*unstructured.UnstructuredList, error) {
-	return oc.dynClient.
-		Resource(groupVersionResource).
-		Namespace(namespace).
-		List(context.Background(), metav1.ListOptions{})
+// impersonate returns an ortController that acts as the given Kubernetes user, so the API server authorizes each call
+// with the RBAC permissions of that user. Its reads bypass the informer caches, since those are filled with the
+// permissions of the API itself.
+func (oc ortController) impersonate(user string, groups []string) (ortController, error) {
+	config := rest.CopyConfig(oc.config)
+	config.Impersonate = rest.ImpersonationConfig{UserName: user, Groups: groups}
+
+	dynClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return ortController{}, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return ortController{}, err
+	}
+
+	namespaces := newNamespaceSet()
+	for _, ns := range oc.namespaces.list() {
+		namespaces.add(ns, newUncachedNamespaceClients(dynClient, ns))
+	}
+
+	return ortController{
+		cluster:          oc.cluster,
+		config:           config,
+		defaultNamespace: oc.defaultNamespace,
+		namespaces:       namespaces,
+		dynClient:        dynClient,
+		clientset:        clientset,
+	}, nil
 }
 
-func (oc ortController) getRun(name string) (*unstructured.Unstructured, error) {
-	return oc.dynClient.
-		Resource(groupVersionResource).
-		Namespace(namespace).
-		Get(context.Background(), name, metav1.GetOptions{})
+// start runs the informers until stop is closed and waits at most namespaceSyncTimeout for their caches to be filled
+func (oc ortController) start(stop <-chan struct{}) error {
+	var synced []cache.InformerSynced
+
+	for _, ns := range oc.namespaces.list() {
+		nc, _ := oc.namespaces.get(ns)
+		nc.start()
+		synced = append(synced, nc.synced...)
+	}
+
+	go func() {
+		<-stop
+		oc.namespaces.stop()
+	}()
+
+	slog.Info("filling the caches of OrtRuns and pods", "cluster", oc.cluster)
+
+	if !waitForCacheSync(namespaceSyncTimeout, synced...) {
+		return fmt.Errorf("the caches of OrtRuns and pods did not fill within %v", namespaceSyncTimeout)
+	}
+	return nil
 }
 
-func (oc ortController) createRun(repoUrl string) (*unstructured.Unstructured, error) {
-	name := autoname.Generate("-")
+// cachesSynced returns whether the caches of all allowed namespaces are filled
+func (oc ortController) cachesSynced() bool {
+	for _, ns := range oc.namespaces.list() {
+		nc, _ := oc.namespaces.get(ns)
+		for _, synced := range nc.synced {
+			if !synced() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (oc ortController) isAllowed(namespace string) bool {
+	_, found := oc.namespaces.get(namespace)
+	return found
+}
 
-	run := &unstructured.Unstructured{
-		Object: map[string]interface{}{
-			"apiVersion": "inocybe.io/v1",
-			"kind":       "OrtRun",
-			"metadata": map[string]interface{}{
-				"namespace": namespace,
-				"name":      name,
-			},
-			"spec": map[string]interface{}{
-				"repoUrl": repoUrl,
-			},
+// resolveNamespace returns the default namespace if namespace is empty and an error if it is not in the allowlist
+func (oc ortController) resolveNamespace(namespace string) (string, error) {
+	if namespace == "" {
+		return oc.defaultNamespace, nil
+	}
+
+	if !oc.isAllowed(namespace) {
+		return "", fmt.Errorf("%w: '%s'", errNamespaceNotAllowed, namespace)
+	}
+	return namespace, nil
+}
+
+// runClient returns the client for the OrtRuns in the given namespace
+func (oc ortController) runClient(namespace string) (ortRunClient, error) {
+	namespace, err := oc.resolveNamespace(namespace)
+	if err != nil {
+		return ortRunClient{}, err
+	}
+
+	nc, found := oc.namespaces.get(namespace)
+	if !found {
+		return ortRunClient{}, fmt.Errorf("%w: '%s'", errNamespaceNotAllowed, namespace)
+	}
+	return nc.runs, nil
+}
+
+// listRuns returns the OrtRuns in namespace matching selector from the informer cache
+func (oc ortController) listRuns(
+	ctx context.Context,
+	namespace string,
+	selector labels.Selector,
+) (_ *ortv1.OrtRunList, err error) {
+	ctx, span := oc.startSpan(ctx, "listRuns", oc.namespaceAttribute(namespace))
+	defer func() { endSpan(span, err) }()
+
+	runs, err := oc.runClient(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return runs.list(ctx, selector)
+}
+
+// listRunsInAllNamespaces returns the OrtRuns matching selector in all allowed namespaces. Namespaces the caller may
+// not read are left out.
+func (oc ortController) listRunsInAllNamespaces(
+	ctx context.Context,
+	selector labels.Selector,
+) (_ *ortv1.OrtRunList, err error) {
+	ctx, span := oc.startSpan(ctx, "listRunsInAllNamespaces")
+	defer func() { endSpan(span, err) }()
+
+	all := &ortv1.OrtRunList{}
+
+	for _, ns := range oc.namespaces.list() {
+		runs, err := oc.runClient(ns)
+		if err != nil {
+			// the namespace was removed from the allowlist in the meantime
+			continue
+		}
+
+		list, err := runs.list(ctx, selector)
+		if apierrors.IsForbidden(err) {
+			// an impersonated caller may only be allowed to read some of the namespaces
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		all.Items = append(all.Items, list.Items...)
+	}
+
+	return all, nil
+}
+
+// listRunsPage returns at most limit OrtRuns matching selector from the API server, starting at continueToken. The
+// informer cache can not be used here, since the continue token of the result refers to the API server's state.
+func (oc ortController) listRunsPage(
+	ctx context.Context,
+	namespace string,
+	selector labels.Selector,
+	limit int64,
+	continueToken string,
+) (_ *ortv1.OrtRunList, err error) {
+	ctx, span := oc.startSpan(ctx, "listRunsPage", oc.namespaceAttribute(namespace))
+	defer func() { endSpan(span, err) }()
+
+	runs, err := oc.runClient(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return runs.listFromServer(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+		Limit:         limit,
+		Continue:      continueToken,
+	})
+}
+
+func (oc ortController) getRun(ctx context.Context, namespace, name string) (_ *ortv1.OrtRun, err error) {
+	ctx, span := oc.startSpan(ctx, "getRun", oc.namespaceAttribute(namespace), attrRunName.String(name))
+	defer func() { endSpan(span, err) }()
+
+	runs, err := oc.runClient(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return runs.get(ctx, name)
+}
+
+// watchRuns watches all OrtRuns or only the one with the given name, if name is not empty. If resourceVersion is empty,
+// the watch starts with an "ADDED" event for every existing OrtRun.
+func (oc ortController) watchRuns(
+	ctx context.Context,
+	namespace string,
+	name string,
+	resourceVersion string,
+) (_ watch.Interface, err error) {
+	ctx, span := oc.startSpan(ctx, "watchRuns", oc.namespaceAttribute(namespace), attrRunName.String(name))
+	defer func() { endSpan(span, err) }()
+
+	runs, err := oc.runClient(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := metav1.ListOptions{
+		ResourceVersion:     resourceVersion,
+		AllowWatchBookmarks: true,
+	}
+
+	if name != "" {
+		opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+	}
+
+	return runs.watch(ctx, opts)
+}
+
+// createRun creates an OrtRun from req, which is expected to be valid. createdBy is recorded in an annotation, unless
+// it is empty.
+func (oc ortController) createRun(
+	ctx context.Context,
+	namespace string,
+	req CreateRunRequest,
+	createdBy string,
+) (_ *ortv1.OrtRun, err error) {
+	ctx, span := oc.startSpan(ctx, "createRun", oc.namespaceAttribute(namespace))
+	defer func() { endSpan(span, err) }()
+
+	spec := ortv1.OrtRunSpec{
+		RepoUrl:          req.RepoUrl,
+		Revision:         req.Revision,
+		Path:             req.Path,
+		AnalyzerOptions:  req.AnalyzerOptions,
+		ScannerOptions:   req.ScannerOptions,
+		PackageCurations: req.PackageCurations,
+	}
+
+	return oc.createRunWithSpec(ctx, namespace, spec, req.Labels, createdByAnnotations(createdBy))
+}
+
+// rerunRun creates a new OrtRun with the same spec as the one with the given name. The source run and the start stage
+// are recorded in annotations, since the CRD of the operator has no fields for them. A startStage of "scanner" or
+// "reporter" is only a hint for an operator that reuses the results of the preceding stages, so those must have
+// succeeded. The current operator does not read it and runs all stages again.
+func (oc ortController) rerunRun(
+	ctx context.Context,
+	namespace string,
+	name string,
+	startStage string,
+	createdBy string,
+) (_ *ortv1.OrtRun, err error) {
+	ctx, span := oc.startSpan(
+		ctx,
+		"rerunRun",
+		oc.namespaceAttribute(namespace),
+		attrRunName.String(name),
+		attrStage.String(startStage),
+	)
+	defer func() { endSpan(span, err) }()
+
+	source, err := oc.getRun(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := source.Spec
+	spec.Abort = false
+
+	annotations := createdByAnnotations(createdBy)
+	annotations[ortv1.SourceRunAnnotation] = name
+
+	if startStage != "" && startStage != "analyzer" {
+		status := runStatusFromResource(source)
+		prerequisites := map[string][]StageStatus{
+			"scanner":  {status.Analyzer},
+			"reporter": {status.Analyzer, status.Scanner},
+		}
+
+		for _, s := range prerequisites[startStage] {
+			if s != Succeeded {
+				return nil, fmt.Errorf("%w: cannot restart run '%s' from the %s", errPrerequisiteStageFailed, name, startStage)
+			}
+		}
+
+		annotations[ortv1.StartStageAnnotation] = startStage
+	}
+
+	return oc.createRunWithSpec(ctx, source.Namespace, spec, source.Labels, annotations)
+}
+
+func (oc ortController) createRunWithSpec(
+	ctx context.Context,
+	namespace string,
+	spec ortv1.OrtRunSpec,
+	labels map[string]string,
+	annotations map[string]string,
+) (_ *ortv1.OrtRun, err error) {
+	ctx, span := oc.startSpan(ctx, "createRunWithSpec", oc.namespaceAttribute(namespace))
+	defer func() { endSpan(span, err) }()
+
+	runs, err := oc.runClient(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if namespace == "" {
+		namespace = oc.defaultNamespace
+	}
+
+	run := &ortv1.OrtRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        generateRunName(),
+			Labels:      labels,
+			Annotations: annotations,
 		},
+		Spec: spec,
 	}
 
-	created, err := oc.dynClient.
-		Resource(groupVersionResource).
-		Namespace(namespace).
-		Create(context.Background(), run, metav1.CreateOptions{})
-	return created, err
+	span.SetAttributes(attrRunName.String(run.Name))
+	return runs.create(ctx, run)
+}
+
+func generateRunName() string {
+	for {
+		if name := autoname.Generate("-"); name != reservedRunName {
+			return name
+		}
+	}
 }
 
-func (oc ortController) listPods(name, stage string) ([]v1.Pod, error) {
-	// TODO only list pods for the OrtRun of the passed in name
-	pods, err := oc.clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+// createdByAnnotations returns the annotations of a new OrtRun created by the given caller, who may be unknown
+func createdByAnnotations(createdBy string) map[string]string {
+	annotations := map[string]string{}
+	if createdBy != "" {
+		annotations[ortv1.CreatedByAnnotation] = createdBy
+	}
+	return annotations
+}
+
+func (oc ortController) deleteRun(
+	ctx context.Context,
+	namespace string,
+	name string,
+	propagation metav1.DeletionPropagation,
+) (err error) {
+	ctx, span := oc.startSpan(ctx, "deleteRun", oc.namespaceAttribute(namespace), attrRunName.String(name))
+	defer func() { endSpan(span, err) }()
+
+	runs, err := oc.runClient(namespace)
+	if err != nil {
+		return err
+	}
+	return runs.delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+}
+
+// abortRun marks the OrtRun as aborted so the operator does not start any further stages and deletes the Jobs of all
+// stages that have not finished yet along with their pods. Deleting only the pods would not stop a stage, since the Job
+// controller replaces them until the backoffLimit of the Job is reached.
+func (oc ortController) abortRun(ctx context.Context, namespace, name string) (_ *ortv1.OrtRun, err error) {
+	ctx, span := oc.startSpan(ctx, "abortRun", oc.namespaceAttribute(namespace), attrRunName.String(name))
+	defer func() { endSpan(span, err) }()
+
+	runs, err := oc.runClient(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// aborting a finished run would report its outcome as Aborted from then on
+	current, err := oc.getRun(ctx, namespace, name)
 	if err != nil {
 		return nil, err
 	}
 
-	prefix := fmt.Sprintf("%s-%s", stage, name)
-	jobPods := []v1.Pod{}
+	if phase := runStatusFromResource(current).phase(); phase == PhaseSucceeded || phase == PhaseFailed {
+		return nil, fmt.Errorf("%w: run '%s' is %s", errRunFinished, name, phase)
+	}
+
+	patch := []byte(`{"spec":{"abort":true}}`)
+
+	patched, err := runs.patch(ctx, name, types.MergePatchType, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := map[string]string{
+		"analyzer": patched.Status.Analyzer,
+		"scanner":  patched.Status.Scanner,
+		"reporter": patched.Status.Reporter,
+	}
+
+	jobs := oc.clientset.BatchV1().Jobs(patched.Namespace)
+	propagation := metav1.DeletePropagationBackground
+
+	for _, stage := range stages {
+		status := stageStatusFromString(statuses[stage])
+		if status == Succeeded || status == Failed {
+			continue
+		}
+
+		job, err := jobs.Get(ctx, stageJobName(stage, name), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// a Job left behind by an earlier OrtRun of the same name belongs to that one
+		if !ownedBy(job.OwnerReferences, patched.UID) {
+			continue
+		}
+
+		opts := metav1.DeleteOptions{
+			PropagationPolicy: &propagation,
+			Preconditions:     metav1.NewUIDPreconditions(string(job.UID)),
+		}
+
+		if err := jobs.Delete(ctx, job.Name, opts); err != nil && !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	return patched, nil
+}
+
+// stageJobName returns the name of the Job the operator creates for a stage of the OrtRun with the given name
+func stageJobName(stage, name string) string {
+	return stage + "-" + name
+}
+
+// ownedBy returns whether one of the owners is the object with the given UID. Objects without owners are treated as
+// owned by anyone, since not every version of the operator sets them.
+func ownedBy(owners []metav1.OwnerReference, uid types.UID) bool {
+	if len(owners) == 0 {
+		return true
+	}
 
-	for _, pod := range pods.Items {
-		if strings.HasPrefix(pod.Name, prefix) {
-			jobPods = append(jobPods, pod)
+	for _, owner := range owners {
+		if owner.UID == uid {
+			return true
 		}
 	}
+	return false
+}
+
+// listPods returns the pods of the given stage of an OrtRun from the informer cache. The pods must not be modified.
+func (oc ortController) listPods(ctx context.Context, namespace, name, stage string) (_ []v1.Pod, err error) {
+	ctx, span := oc.startSpan(
+		ctx,
+		"listPods",
+		oc.namespaceAttribute(namespace),
+		attrRunName.String(name),
+		attrStage.String(stage),
+	)
+	defer func() { endSpan(span, err) }()
+
+	namespace, err = oc.resolveNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	// the Job controller labels the pods of a Job with its name, which unlike a prefix of the pod names does not match
+	// the pods of OrtRuns whose names start with this one
+	selector := labels.SelectorFromSet(labels.Set{jobNameLabel: stageJobName(stage, name)})
+
+	pods, err := oc.pods(ctx, namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	jobPods := make([]v1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		jobPods = append(jobPods, *pod)
+	}
 
 	return jobPods, nil
 }
 
-func (oc ortController) getLogs(podName string) (string, error) {
+// hasPodCache returns whether the pods of the namespace are read from an informer cache
+func (oc ortController) hasPodCache(namespace string) bool {
+	nc, found := oc.namespaces.get(namespace)
+	return found && nc.pods != nil
+}
+
+// pods returns the pods matching selector from the informer cache or, without cache, from the API server
+func (oc ortController) pods(ctx context.Context, namespace string, selector labels.Selector) ([]*v1.Pod, error) {
+	if nc, found := oc.namespaces.get(namespace); found && nc.pods != nil {
+		return nc.pods.List(selector)
+	}
+
+	list, err := oc.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	pods := make([]*v1.Pod, len(list.Items))
+	for i := range list.Items {
+		pods[i] = &list.Items[i]
+	}
+
+	return pods, nil
+}
+
+// ortRun converts an OrtRun resource to its API representation. Stage timings missing from the status of the resource
+// are derived from the pods of the stage, but only from the informer cache. Without cache, e.g. when impersonating the
+// caller, that would take a request to the API server for each stage of each run and fail for callers who may read
+// OrtRuns but not pods, so those timings are left out.
+func (oc ortController) ortRun(ctx context.Context, resource *ortv1.OrtRun, withYaml bool) (_ OrtRun, err error) {
+	ctx, span := oc.startSpan(ctx, "ortRun", oc.namespaceAttribute(resource.Namespace), attrRunName.String(resource.Name))
+	defer func() { endSpan(span, err) }()
+
+	run, err := resourceToOrtRun(resource, withYaml)
+	if err != nil {
+		return run, err
+	}
+
+	run.Cluster = oc.cluster
+
+	if !oc.hasPodCache(run.Namespace) {
+		run.Timings.updateDurations(run.Status, time.Now())
+		return run, nil
+	}
+
+	statuses := run.Status.byStage()
+
+	for stage, timing := range run.Timings.byStage() {
+		if statuses[stage] == Pending || (timing.StartedAt != nil && timing.FinishedAt != nil) {
+			continue
+		}
+
+		pods, err := oc.listPods(ctx, run.Namespace, run.Name, stage)
+		if err != nil {
+			return run, err
+		}
+
+		fromPods := stageTimingFromPods(pods)
+		if timing.StartedAt == nil {
+			timing.StartedAt = fromPods.StartedAt
+		}
+		if timing.FinishedAt == nil && statuses[stage] != Running {
+			timing.FinishedAt = fromPods.FinishedAt
+		}
+	}
+
+	run.Timings.updateDurations(run.Status, time.Now())
+	return run, nil
+}
+
+func (oc ortController) ortRunList(ctx context.Context, list *ortv1.OrtRunList) (_ OrtRunList, err error) {
+	ctx, span := oc.startSpan(ctx, "ortRunList")
+	defer func() { endSpan(span, err) }()
+
+	runList := OrtRunList{Continue: list.Continue}
+	var runs []OrtRun
+
+	for _, item := range list.Items {
+		run, err := oc.ortRun(ctx, &item, false)
+		if err != nil {
+			return runList, err
+		}
+
+		runs = append(runs, run)
+	}
+
+	runList.Runs = runs
+	return runList, nil
+}
+
+// stageTimingFromPods derives the timing of a stage from the earliest start time of its pods and the latest time one of
+// their containers terminated. The stage only counts as finished if all containers have terminated.
+func stageTimingFromPods(pods []v1.Pod) StageTiming {
+	timing := StageTiming{}
+	finished := len(pods) > 0
+
+	for _, pod := range pods {
+		if start := pod.Status.StartTime; start != nil && (timing.StartedAt == nil || start.Time.Before(*timing.StartedAt)) {
+			startedAt := start.Time
+			timing.StartedAt = &startedAt
+		}
+
+		if len(pod.Status.ContainerStatuses) == 0 {
+			finished = false
+		}
+
+		for _, container := range pod.Status.ContainerStatuses {
+			terminated := container.State.Terminated
+			if terminated == nil {
+				finished = false
+				continue
+			}
+
+			if timing.FinishedAt == nil || terminated.FinishedAt.Time.After(*timing.FinishedAt) {
+				finishedAt := terminated.FinishedAt.Time
+				timing.FinishedAt = &finishedAt
+			}
+		}
+	}
+
+	if !finished {
+		timing.FinishedAt = nil
+	}
+
+	return timing
+}
+
+// streamLogs follows the logs of the pod with the given name until it terminates or ctx is cancelled. The Follow field
+// of opts is ignored.
+func (oc ortController) streamLogs(
+	ctx context.Context,
+	namespace string,
+	podName string,
+	opts v1.PodLogOptions,
+) (_ io.ReadCloser, err error) {
+	ctx, span := oc.startSpan(ctx, "streamLogs", oc.namespaceAttribute(namespace), semconv.K8SPodName(podName))
+	defer func() { endSpan(span, err) }()
+
+	namespace, err = oc.resolveNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Follow = true
+
+	return oc.clientset.CoreV1().
+		Pods(namespace).
+		GetLogs(podName, &opts).
+		Stream(ctx)
+}
+
+func (oc ortController) getLogs(
+	ctx context.Context,
+	namespace string,
+	podName string,
+	opts v1.PodLogOptions,
+) (_ string, err error) {
+	ctx, span := oc.startSpan(ctx, "getLogs", oc.namespaceAttribute(namespace), semconv.K8SPodName(podName))
+	defer func() { endSpan(span, err) }()
+
+	namespace, err = oc.resolveNamespace(namespace)
+	if err != nil {
+		return "", err
+	}
+
+	opts.Follow = false
+
 	logs, err := oc.clientset.CoreV1().
 		Pods(namespace).
-		GetLogs(podName, new(v1.PodLogOptions)).
-		DoRaw(context.Background())
+		GetLogs(podName, &opts).
+		DoRaw(ctx)
 
 	if err != nil {
 		return "", err
@@ -134,15 +766,22 @@ func (oc ortController) getLogs(podName string) (string, error) {
 	return string(logs), nil
 }
 
+func isStage(name string) bool {
+	for _, stage := range stages {
+		if stage == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfig tries the in-cluster config first and the default kubeconfig second, i.e. the files listed in KUBECONFIG
+// or $HOME/.kube/config
 func loadConfig() (*rest.Config, error) {
 	if config, err := rest.InClusterConfig(); err == nil {
 		return config, nil
 	}
 
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return nil, err
-	}
-
-	return clientcmd.BuildConfigFromFlags("", path.Join(home, ".kube/config"))
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
 }
@@ -0,0 +1,105 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// requestIdHeader carries the ID of a request. IDs sent by clients or proxies are kept, otherwise one is generated.
+const requestIdHeader = "X-Request-Id"
+
+// maxRequestIdLength limits the length of request IDs taken from clients
+const maxRequestIdLength = 128
+
+// logLevel is the minimum level of the records that are logged. It can be changed at runtime.
+var logLevel = new(slog.LevelVar)
+
+type loggerKey struct{}
+
+// setupLogging makes a JSON logger writing to stderr the default logger, which the log package used by libraries
+// writes to as well
+func setupLogging() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+}
+
+// fatal logs msg and args at level error and terminates the process
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// withLogger returns a copy of ctx carrying logger
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger of ctx, which adds the fields of the request or command being handled, or the default
+// logger if there is none
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// withRequestId wraps a handler so that each request has an ID, which is sent back in the response header
+// X-Request-Id. The logger of the request context adds the ID, the name of the handler and the ID of the trace.
+func withRequestId(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIdHeader)
+		if !validRequestId(id) {
+			id = newRequestId()
+		}
+
+		w.Header().Set(requestIdHeader, id)
+		logger := slog.Default().With("request_id", id, "handler", name)
+
+		if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+			logger = logger.With("trace_id", sc.TraceID().String())
+		}
+
+		next.ServeHTTP(w, r.WithContext(withLogger(r.Context(), logger)))
+	})
+}
+
+// validRequestId returns whether id is short and only contains printable ASCII characters other than spaces
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	// crypto/rand never fails on the supported platforms
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
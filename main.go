@@ -17,32 +17,160 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-var (
-	matrixServer      = os.Getenv("MATRIX_SERVER")
-	matrixUser        = os.Getenv("MATRIX_USER")
-	matrixAccessToken = os.Getenv("MATRIX_ACCESS_TOKEN")
-)
+// shutdownTimeout limits how long in-flight requests and bot commands may take to finish after SIGTERM
+const shutdownTimeout = 30 * time.Second
 
 func main() {
-	if matrixServer != "" {
-		bot, err := newMatrixBot(matrixServer, matrixUser, matrixAccessToken)
+	setupLogging()
+
+	ctx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stopSignals()
+
+	cfg, err := readConfig(os.Args[1:])
+	if err != nil {
+		fatal("failed to read the config", "error", err)
+	}
+
+	// the level was validated along with the rest of the config
+	_ = logLevel.UnmarshalText([]byte(cfg.LogLevel))
+
+	shutdownTracing, err := setupTracing(ctx, cfg.Tracing)
+	if err != nil {
+		fatal("failed to set up tracing", "error", err)
+	}
+
+	cl, err := newClusters(cfg.clusterConfigs(), cfg.Namespace, cfg.Namespaces)
+	if err != nil {
+		fatal("failed to connect to Kubernetes", "error", err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	if err := cl.start(stop); err != nil {
+		fatal("failed to fill the caches of OrtRuns and pods", "error", err)
+	}
+
+	live, err := newLiveConfig(ctx, cfg, cl)
+	if err != nil {
+		fatal("failed to load the authentication and authorization config", "error", err)
+	}
+
+	var botsDone []<-chan struct{}
+	h := health{clusters: cl}
+
+	if cfg.Matrix.Server != "" {
+		bot, err := newMatrixBot(ctx, cl, live.policy, cfg.Matrix, live.reportUrl)
 		if err != nil {
-			log.Fatal(err)
+			fatal("failed to create the Matrix bot", "platform", "matrix", "error", err)
 		}
 
-		bot.run()
+		botsDone = append(botsDone, bot.run())
+		live.matrixBot = &bot
+		h.bots = append(h.bots, readinessCheck{name: "bot/matrix", check: bot.status.check})
+	}
+
+	if cfg.Slack.Enabled {
+		bot, err := newSlackBot(cl.defaultController(), cfg.Slack)
+		if err != nil {
+			fatal("failed to create the Slack bot", "platform", "slack", "error", err)
+		}
+
+		botsDone = append(botsDone, bot.run(ctx))
+		live.slackBot = &bot
+		h.bots = append(h.bots, readinessCheck{name: "bot/slack", check: bot.status.check})
+	}
+
+	if len(*live.authenticators.Load()) == 0 {
+		slog.Warn("no authentication configured, the API is open to anyone who can reach it")
 	}
 
+	shutdown := make(chan struct{})
+	a := api{clusters: cl, policy: live.policy, shutdown: shutdown}
+
+	if cfg.Impersonation.Enabled {
+		a.impersonation = &impersonation{cfg.Impersonation.UserPrefix, cfg.Impersonation.GroupPrefix}
+	}
+
+	prometheus.MustRegister(runCollector{cl})
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/runs", handleRuns)
-	mux.HandleFunc("/runs/", handleGetRun)
-	mux.HandleFunc("/logs/", handleLogs)
+	route := func(pattern, name string, handler http.Handler) {
+		handler = withRequestId(name, instrumentHandler(name, authenticate(handler, live.authenticators)))
+		mux.Handle(pattern, otelhttp.NewHandler(handler, pattern, otelhttp.WithSpanNameFormatter(
+			func(_ string, r *http.Request) string { return r.Method + " " + pattern },
+		)))
+	}
+
+	route("/runs", "runs", a.withController(api.handleRuns))
+	route("/runs/", "run", a.withController(api.handleRun))
+	route("/logs/", "logs", a.withController(api.handleLogs))
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", h.handleHealthz)
+	mux.HandleFunc("/readyz", h.handleReadyz)
 
-	log.Print("Starting server on :4000")
-	log.Fatal(http.ListenAndServe(":4000", mux))
+	server := &http.Server{Addr: cfg.Listen, Handler: mux}
+	// streams of events and logs would keep their connections busy forever, so they are ended right away
+	server.RegisterOnShutdown(func() { close(shutdown) })
+
+	go live.watch(ctx)
+
+	go func() {
+		var err error
+
+		if cfg.TLS.CertFile != "" {
+			slog.Info("starting server with TLS", "address", cfg.Listen)
+			err = server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			slog.Info("starting server", "address", cfg.Listen)
+			err = server.ListenAndServe()
+		}
+
+		if !errors.Is(err, http.ErrServerClosed) {
+			fatal("failed to serve", "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	// a second signal terminates the process right away
+	stopSignals()
+
+	slog.Info("shutting down")
+	gracefulShutdown(server, botsDone, shutdownTracing)
+}
+
+// gracefulShutdown stops accepting requests and waits for in-flight requests and bot commands to finish, at most for
+// shutdownTimeout. The bots are expected to stop on their own. The remaining spans are exported last.
+func gracefulShutdown(server *http.Server, botsDone []<-chan struct{}, shutdownTracing func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("failed to shut down the server", "error", err)
+	}
+
+	for _, done := range botsDone {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			slog.Warn("the bots did not stop in time")
+		}
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("failed to export the remaining spans", "error", err)
+	}
 }
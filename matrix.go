@@ -17,47 +17,186 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	ortv1 "github.com/haikoschol/ort-operator-api/api/v1"
 	"github.com/matrix-org/gomatrix"
-	"log"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/labels"
+	"log/slog"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// matrixCommandTimeout limits how long the Kubernetes calls of a single command may take
+const matrixCommandTimeout = time.Minute
+
+var errMatrixSyncStopped = errors.New("the Matrix sync loop was stopped")
+
+// matrixBot handles commands sent to it in Matrix rooms until ctx is done. Commands already being handled then are not
+// cancelled, but may take up to matrixCommandTimeout to finish. Each command is handled with oc set to the controller of
+// the cluster selected by the command. The policy, the report URL template and the client can be replaced at runtime.
 type matrixBot struct {
-	oc     ortController
-	client *gomatrix.Client
+	ctx       context.Context
+	clusters  clusters
+	oc        ortController
+	policy    *atomic.Pointer[policy]
+	reportUrl *atomic.Pointer[string]
+	client    *atomic.Pointer[gomatrix.Client]
+	status    *botStatus
+	commands  *inFlight
 }
 
-func newMatrixBot(server, user, accessToken string) (matrixBot, error) {
-	oc, err := newOrtController()
-	if err != nil {
-		return matrixBot{}, err
+// inFlight tracks the commands being handled, so that stopping the bot can wait for them to finish
+type inFlight struct {
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// start registers a command about to be handled. It returns false if the bot is stopping and the command must be dropped.
+func (f *inFlight) start() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.stopped {
+		return false
 	}
 
-	client, err := gomatrix.NewClient(server, user, accessToken)
+	f.wg.Add(1)
+	return true
+}
+
+func (f *inFlight) done() {
+	f.wg.Done()
+}
+
+// stop refuses all further commands and waits for the ones already started
+func (f *inFlight) stop() {
+	f.mu.Lock()
+	f.stopped = true
+	f.mu.Unlock()
+
+	f.wg.Wait()
+}
+
+// commandPermissions are the permissions needed for each command. Commands not listed here need none.
+var commandPermissions = map[string]permission{
+	"create": permCreate,
+	"list":   permRead,
+	"show":   permRead,
+	"abort":  permAbort,
+	"rerun":  permCreate,
+}
+
+// newMatrixBot creates a bot for the given config. reportUrl holds the template of the URLs of reports it links to.
+func newMatrixBot(
+	ctx context.Context,
+	cl clusters,
+	pol *atomic.Pointer[policy],
+	cfg matrixConfig,
+	reportUrl *atomic.Pointer[string],
+) (matrixBot, error) {
+	client, err := gomatrix.NewClient(cfg.Server, cfg.User, cfg.AccessToken)
 	if err != nil {
 		return matrixBot{}, err
 	}
 
-	return matrixBot{
-		oc,
-		client,
-	}, nil
-}
+	mb := matrixBot{
+		ctx:       ctx,
+		clusters:  cl,
+		policy:    pol,
+		reportUrl: reportUrl,
+		client:    &atomic.Pointer[gomatrix.Client]{},
+		status:    &botStatus{},
+		commands:  &inFlight{},
+	}
 
-func (mb matrixBot) run() {
-	syncer := mb.client.Syncer.(*gomatrix.DefaultSyncer)
+	syncer := client.Syncer.(*gomatrix.DefaultSyncer)
 	syncer.OnEventType("m.room.message", mb.handleMessage)
+	client.Syncer = matrixSyncer{syncer, mb.stopped(client), mb.status}
+
+	mb.client.Store(client)
+	return mb, nil
+}
+
+// run syncs with the homeserver and handles commands in the background until the context of the bot is done. The
+// returned channel is closed once the commands being handled at that time are finished. It does not wait for the
+// running sync request, which is a long poll that may take as long as the whole shutdown.
+func (mb matrixBot) run() <-chan struct{} {
+	done := make(chan struct{})
 
-	// TODO teardown
 	go func() {
-		for {
-			if err := mb.client.Sync(); err != nil {
-				log.Printf("failed to sync state with matrix server %v: %v\n", mb.client.HomeserverURL, err)
+		for mb.ctx.Err() == nil {
+			client := mb.client.Load()
+			err := client.Sync()
+			if err != nil && !errors.Is(err, errMatrixSyncStopped) {
+				slog.Error("failed to sync with the Matrix homeserver", "platform", "matrix",
+					"server", client.HomeserverURL.String(), "error", err)
 			}
 		}
 	}()
+
+	go func() {
+		defer close(done)
+
+		<-mb.ctx.Done()
+		mb.client.Load().StopSync()
+		mb.commands.stop()
+	}()
+
+	return done
+}
+
+// setAccessToken replaces the client of the bot with one using the given access token. The sync loop switches to the
+// new client once the running sync request of the old one returns and continues where the old one left off.
+func (mb matrixBot) setAccessToken(accessToken string) error {
+	current := mb.client.Load()
+
+	client, err := gomatrix.NewClient(current.HomeserverURL.String(), current.UserID, accessToken)
+	if err != nil {
+		return err
+	}
+
+	client.Store = current.Store
+	client.Syncer = matrixSyncer{current.Syncer.(matrixSyncer).DefaultSyncer, mb.stopped(client), mb.status}
+
+	mb.client.Store(client)
+	current.StopSync()
+	return nil
+}
+
+// stopped returns a function reporting whether client should stop syncing, because it was replaced or the bot stops
+func (mb matrixBot) stopped(client *gomatrix.Client) func() bool {
+	return func() bool {
+		return mb.client.Load() != client || mb.ctx.Err() != nil
+	}
+}
+
+// matrixSyncer ends the sync loop of a stopped client on the first failed sync request. Without it, a client whose
+// access token was revoked would retry forever. It records the outcome of each sync request in status.
+type matrixSyncer struct {
+	*gomatrix.DefaultSyncer
+	stopped func() bool
+	status  *botStatus
+}
+
+func (s matrixSyncer) ProcessResponse(res *gomatrix.RespSync, since string) error {
+	s.status.set(nil)
+	return s.DefaultSyncer.ProcessResponse(res, since)
+}
+
+func (s matrixSyncer) OnFailedSync(res *gomatrix.RespSync, err error) (time.Duration, error) {
+	if s.stopped() {
+		return 0, errMatrixSyncStopped
+	}
+
+	s.status.set(err)
+	return s.DefaultSyncer.OnFailedSync(res, err)
 }
 
 func (mb matrixBot) handleMessage(ev *gomatrix.Event) {
@@ -66,8 +205,9 @@ func (mb matrixBot) handleMessage(ev *gomatrix.Event) {
 		return
 	}
 
-	suffix := fmt.Sprintf(":%s", mb.client.HomeserverURL.Host)
-	shortUid, _ := strings.CutSuffix(mb.client.UserID, suffix)
+	client := mb.client.Load()
+	suffix := fmt.Sprintf(":%s", client.HomeserverURL.Host)
+	shortUid, _ := strings.CutSuffix(client.UserID, suffix)
 	body, found := strings.CutPrefix(body, shortUid)
 	if !found {
 		return
@@ -78,88 +218,211 @@ func (mb matrixBot) handleMessage(ev *gomatrix.Event) {
 	cmd = strings.TrimSpace(cmd)
 	args = strings.TrimSpace(args)
 
+	if !mb.commands.start() {
+		return
+	}
+	defer mb.commands.done()
+
 	mb.handleCommand(ev, cmd, args)
 }
 
 func (mb matrixBot) handleCommand(ev *gomatrix.Event, command, arguments string) {
+	countBotCommand("matrix", command)
+
+	// stopping the bot must not cancel a command halfway through, e.g. between creating and reporting a run
+	ctx, span := tracer.Start(
+		context.WithoutCancel(mb.ctx),
+		"matrixBot "+botCommandName(command),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrCommand.String(botCommandName(command))),
+	)
+	defer span.End()
+
+	logger := matrixLogger(ev).With("command", botCommandName(command))
+	ctx = withLogger(ctx, logger)
+	logger.Debug("handling command")
+
+	// the Matrix ID of the sender is the identity of the caller, since the homeserver already authenticated them
+	if perm, found := commandPermissions[command]; found && !mb.policy.Load().allows(identity{name: ev.Sender}, perm) {
+		logger.Warn("command not allowed", "permission", perm)
+		message := fmt.Sprintf("you are not allowed to run '%s', it needs the permission '%s'", command, perm)
+		mb.sendCommandResponse(ev, message)
+		return
+	}
+
+	cluster, arguments, err := parseOption(arguments, "cluster")
+	if err != nil {
+		mb.sendCommandResponse(ev, err.Error())
+		return
+	}
+
+	namespace, arguments, err := parseOption(arguments, "namespace")
+	if err != nil {
+		mb.sendCommandResponse(ev, err.Error())
+		return
+	}
+
+	// mb is a copy, so this only affects the handling of this command
+	if mb.oc, err = mb.clusters.controller(cluster); err != nil {
+		mb.sendCommandResponse(ev, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, matrixCommandTimeout)
+	defer cancel()
+
 	switch command {
 	case "help":
 		mb.sendCommandResponse(ev, chatbotHelpText)
 	case "create":
-		mb.handleCreateCommand(ev, arguments)
+		mb.handleCreateCommand(ctx, ev, namespace, arguments)
 	case "list":
-		mb.handleListCommand(ev)
+		mb.handleListCommand(ctx, ev, namespace, arguments)
 	case "show":
-		mb.handleShowCommand(ev, arguments)
+		mb.handleShowCommand(ctx, ev, namespace, arguments)
+	case "abort":
+		mb.handleAbortCommand(ctx, ev, namespace, arguments)
+	case "rerun":
+		mb.handleRerunCommand(ctx, ev, namespace, arguments)
 	default:
 		message := fmt.Sprintf("unknown command '%s'. Use 'help' to list all available commands", command)
 		mb.sendCommandResponse(ev, message)
 	}
 }
 
-func (mb matrixBot) handleCreateCommand(ev *gomatrix.Event, repoUrl string) {
-	run, err := mb.oc.createRun(repoUrl)
+func (mb matrixBot) handleCreateCommand(ctx context.Context, ev *gomatrix.Event, namespace, arguments string) {
+	req, err := parseCreateArguments(arguments)
 	if err != nil {
 		mb.sendCommandResponse(ev, err.Error())
 		return
 	}
 
-	data, err := json.MarshalIndent(run, "", "    ")
+	if errs := req.validate(); len(errs) > 0 {
+		mb.sendCommandResponse(ev, errs.ToAggregate().Error())
+		return
+	}
+
+	run, err := mb.oc.createRun(ctx, namespace, req, ev.Sender)
+	if err != nil {
+		mb.sendCommandResponse(ev, err.Error())
+		return
+	}
+
+	mb.sendRunResponse(ctx, ev, run, false)
+}
+
+func (mb matrixBot) handleListCommand(ctx context.Context, ev *gomatrix.Event, namespace, arguments string) {
+	var runs *ortv1.OrtRunList
+	var err error
+
+	switch arguments {
+	case "":
+		runs, err = mb.oc.listRuns(ctx, namespace, labels.Everything())
+	case "--all-namespaces":
+		if namespace != "" {
+			mb.sendCommandResponse(ev, "--all-namespaces can not be combined with --namespace")
+			return
+		}
+		runs, err = mb.oc.listRunsInAllNamespaces(ctx, labels.Everything())
+	default:
+		mb.sendCommandResponse(ev, fmt.Sprintf("unknown argument '%s'", arguments))
+		return
+	}
+
+	if err != nil {
+		mb.sendCommandResponse(ev, err.Error())
+		return
+	}
+
+	runList, err := mb.oc.ortRunList(ctx, runs)
 	if err != nil {
 		mb.sendCommandResponse(ev, err.Error())
 		return
 	}
 
-	mb.sendCommandResponse(ev, string(data))
+	html := fmt.Sprintf("%s %s", ev.Sender, runListToHtml(runList, *mb.reportUrl.Load()))
+
+	if _, err = mb.client.Load().SendFormattedText(ev.RoomID, "", html); err != nil {
+		loggerFrom(ctx).Error("failed to send run list", "error", err)
+	}
 }
 
-func (mb matrixBot) handleListCommand(ev *gomatrix.Event) {
-	runs, err := mb.oc.listRuns()
+func (mb matrixBot) handleShowCommand(ctx context.Context, ev *gomatrix.Event, namespace, name string) {
+	run, err := mb.oc.getRun(ctx, namespace, name)
 	if err != nil {
 		mb.sendCommandResponse(ev, err.Error())
 		return
 	}
 
-	html, err := runListToHtml(runs)
+	mb.sendRunResponse(ctx, ev, run, true)
+}
+
+func (mb matrixBot) handleAbortCommand(ctx context.Context, ev *gomatrix.Event, namespace, name string) {
+	run, err := mb.oc.abortRun(ctx, namespace, name)
 	if err != nil {
 		mb.sendCommandResponse(ev, err.Error())
 		return
 	}
 
-	html = fmt.Sprintf("%s %s", ev.Sender, html)
+	mb.sendRunResponse(ctx, ev, run, false)
+}
 
-	if _, err = mb.client.SendFormattedText(ev.RoomID, "", html); err != nil {
-		log.Println("failed to send run list as HTML to Matrix: %v\n", err)
+func (mb matrixBot) handleRerunCommand(ctx context.Context, ev *gomatrix.Event, namespace, arguments string) {
+	name, stage, _ := strings.Cut(arguments, " ")
+	stage = strings.TrimSpace(stage)
+
+	if stage != "" && !isStage(stage) {
+		mb.sendCommandResponse(ev, fmt.Sprintf("unknown stage '%s'. Use one of %s", stage, strings.Join(stages, ", ")))
+		return
+	}
+
+	run, err := mb.oc.rerunRun(ctx, namespace, name, stage, ev.Sender)
+	if err != nil {
+		mb.sendCommandResponse(ev, err.Error())
+		return
 	}
+
+	mb.sendRunResponse(ctx, ev, run, false)
 }
 
-func (mb matrixBot) handleShowCommand(ev *gomatrix.Event, name string) {
-	run, err := mb.oc.getRun(name)
+// sendRunResponse sends the API representation of an OrtRun as JSON and, if withYaml is true, the resource itself as
+// YAML to the sender of the command
+func (mb matrixBot) sendRunResponse(ctx context.Context, ev *gomatrix.Event, resource *ortv1.OrtRun, withYaml bool) {
+	run, err := mb.oc.ortRun(ctx, resource, withYaml)
 	if err != nil {
 		mb.sendCommandResponse(ev, err.Error())
 		return
 	}
 
+	resourceYaml := run.KubernetesResource
+	run.KubernetesResource = ""
+
 	data, err := json.MarshalIndent(run, "", "    ")
 	if err != nil {
 		mb.sendCommandResponse(ev, err.Error())
 		return
 	}
 
-	mb.sendCommandResponse(ev, string(data))
+	message := string(data)
+	if resourceYaml != "" {
+		message = fmt.Sprintf("%s\n\n%s", message, resourceYaml)
+	}
+
+	mb.sendCommandResponse(ev, message)
 }
 
 // sendCommandResponse sends the message to the sender of the command and only logs locally in case of error
 func (mb matrixBot) sendCommandResponse(ev *gomatrix.Event, message string) {
 	message = fmt.Sprintf("%s %s", ev.Sender, message)
+	client := mb.client.Load()
 
-	_, err := mb.client.SendText(ev.RoomID, message)
+	_, err := client.SendText(ev.RoomID, message)
 	if err != nil {
-		log.Printf(
-			"failed to send command response to matrix server %v. error: '%v' message: '%s'\n",
-			mb.client.HomeserverURL,
-			err,
-			message,
-		)
+		matrixLogger(ev).Error("failed to send command response", "message", message, "error", err)
 	}
 }
+
+// matrixLogger returns a logger adding the sender and the room of the event
+func matrixLogger(ev *gomatrix.Event) *slog.Logger {
+	return slog.With("platform", "matrix", "user", ev.Sender, "room", ev.RoomID)
+}
@@ -18,8 +18,15 @@ package main
 
 import (
 	"encoding/json"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	ortv1 "github.com/haikoschol/ort-operator-api/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"net/url"
+	"path"
 	"sigs.k8s.io/yaml"
+	"strings"
+	"time"
 )
 
 type StageStatus int
@@ -32,21 +39,75 @@ const (
 	Aborted
 )
 
+// RunPhase is the overall state of an OrtRun, derived from the statuses of its stages
+type RunPhase string
+
+const (
+	PhaseQueued    RunPhase = "Queued"
+	PhaseRunning   RunPhase = "Running"
+	PhaseSucceeded RunPhase = "Succeeded"
+	PhaseFailed    RunPhase = "Failed"
+	PhaseAborted   RunPhase = "Aborted"
+)
+
 type RunStatus struct {
 	Analyzer StageStatus `json:"analyzer"`
 	Scanner  StageStatus `json:"scanner"`
 	Reporter StageStatus `json:"reporter"`
 }
 
+// StageTiming holds when a stage started and finished. DurationSeconds is the time until now for stages that are still
+// running and 0 for stages that are no longer running but whose end is unknown, e.g. because their pods were deleted.
+type StageTiming struct {
+	StartedAt       *time.Time `json:"startedAt,omitempty"`
+	FinishedAt      *time.Time `json:"finishedAt,omitempty"`
+	DurationSeconds int64      `json:"durationSeconds,omitempty"`
+}
+
+type StageTimings struct {
+	Analyzer StageTiming `json:"analyzer"`
+	Scanner  StageTiming `json:"scanner"`
+	Reporter StageTiming `json:"reporter"`
+}
+
 type OrtRun struct {
-	Name               string    `json:"name"`
-	RepoUrl            string    `json:"repoUrl"`
-	Status             RunStatus `json:"status"`
-	KubernetesResource string    `json:"kubernetesResource,omitempty"`
+	Name               string            `json:"name"`
+	Namespace          string            `json:"namespace"`
+	Cluster            string            `json:"cluster"`
+	CreatedAt          time.Time         `json:"createdAt"`
+	RepoUrl            string            `json:"repoUrl"`
+	Revision           string            `json:"revision,omitempty"`
+	Path               string            `json:"path,omitempty"`
+	AnalyzerOptions    map[string]string `json:"analyzerOptions,omitempty"`
+	ScannerOptions     map[string]string `json:"scannerOptions,omitempty"`
+	PackageCurations   string            `json:"packageCurations,omitempty"`
+	Labels             map[string]string `json:"labels,omitempty"`
+	SourceRun          string            `json:"sourceRun,omitempty"`
+	CreatedBy          string            `json:"createdBy,omitempty"`
+	Phase              RunPhase          `json:"phase"`
+	Status             RunStatus         `json:"status"`
+	Timings            StageTimings      `json:"timings"`
+	KubernetesResource string            `json:"kubernetesResource,omitempty"`
+}
+
+// CreateRunRequest is the payload for creating an OrtRun. AnalyzerOptions and ScannerOptions are ORT configuration
+// properties (like "ort.analyzer.allowDynamicVersions") that are passed to the respective stage. PackageCurations is
+// the name of a ConfigMap in the namespace of the OrtRun that contains package curations.
+type CreateRunRequest struct {
+	RepoUrl          string            `json:"repoUrl"`
+	Revision         string            `json:"revision,omitempty"`
+	Path             string            `json:"path,omitempty"`
+	AnalyzerOptions  map[string]string `json:"analyzerOptions,omitempty"`
+	ScannerOptions   map[string]string `json:"scannerOptions,omitempty"`
+	PackageCurations string            `json:"packageCurations,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
 }
 
+// OrtRunList is a list of OrtRuns. If Continue is not empty, there are more runs to fetch by passing it as the query
+// parameter "continue".
 type OrtRunList struct {
-	Runs []OrtRun `json:"runs"`
+	Runs     []OrtRun `json:"runs"`
+	Continue string   `json:"continue,omitempty"`
 }
 
 // FIXME bad naming: "PodLogs" used twice
@@ -61,15 +122,165 @@ type OrtLogs struct {
 	PodLogs  []PodLogs `json:"podLogs"`
 }
 
-func unstructuredToOrtRun(resource *unstructured.Unstructured, withYaml bool) (OrtRun, error) {
+// Problem is the body of error responses as described in RFC 7807. Reason carries the reason reported by the
+// Kubernetes API, if the error originated there.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// HealthReport is the body of the responses of /healthz and /readyz. Status is "ok", "degraded" or "failed".
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthCheck is the result of a single check of /readyz. Error is only set if the check failed.
+type HealthCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// LogLine is a single line from the logs of a pod, sent when following the logs of a stage
+type LogLine struct {
+	PodName string `json:"podName"`
+	Line    string `json:"line,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// validate returns all problems with the request, with field paths matching the OrtRun spec
+func (req CreateRunRequest) validate() field.ErrorList {
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+
+	// the operator passes repoUrl, revision and path on to Git, which would take a leading '-' for an option
+	if req.RepoUrl == "" {
+		errs = append(errs, field.Required(spec.Child("repoUrl"), ""))
+	} else if !isRepoUrl(req.RepoUrl) {
+		errs = append(errs, field.Invalid(spec.Child("repoUrl"), req.RepoUrl, "must be a URL or of the form user@host:path"))
+	}
+
+	if req.Revision != "" && !isRevision(req.Revision) {
+		errs = append(errs, field.Invalid(spec.Child("revision"), req.Revision, "must be a valid Git branch, tag or commit"))
+	}
+
+	if req.Path != "" {
+		cleaned := path.Clean(req.Path)
+		if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			errs = append(errs, field.Invalid(spec.Child("path"), req.Path, "must be relative to the repository root"))
+		} else if strings.HasPrefix(req.Path, "-") {
+			errs = append(errs, field.Invalid(spec.Child("path"), req.Path, "must not start with '-'"))
+		}
+	}
+
+	errs = append(errs, validateOptions(spec.Child("analyzerOptions"), req.AnalyzerOptions)...)
+	errs = append(errs, validateOptions(spec.Child("scannerOptions"), req.ScannerOptions)...)
+
+	if req.PackageCurations != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(req.PackageCurations) {
+			errs = append(errs, field.Invalid(spec.Child("packageCurations"), req.PackageCurations, msg))
+		}
+	}
+
+	labels := field.NewPath("metadata", "labels")
+	for key, value := range req.Labels {
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, field.Invalid(labels, key, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(value) {
+			errs = append(errs, field.Invalid(labels.Key(key), value, msg))
+		}
+	}
+
+	return errs
+}
+
+func validateOptions(fieldPath *field.Path, options map[string]string) field.ErrorList {
+	var errs field.ErrorList
+
+	for key := range options {
+		if key == "" || strings.ContainsAny(key, " =\t\n") {
+			errs = append(errs, field.Invalid(fieldPath, key, "must be a non-empty option name without '=' or whitespace"))
+		}
+	}
+
+	return errs
+}
+
+func isRepoUrl(repoUrl string) bool {
+	if strings.HasPrefix(repoUrl, "-") {
+		return false
+	}
+
+	if u, err := url.Parse(repoUrl); err == nil && u.Scheme != "" && u.Host != "" {
+		return true
+	}
+
+	// scp-like syntax supported by Git, e.g. git@github.com:haikoschol/cats-of-asia.git
+	userHost, repoPath, found := strings.Cut(repoUrl, ":")
+	return found && strings.Contains(userHost, "@") && repoPath != "" && !strings.Contains(userHost, "/")
+}
+
+// isRevision checks the revision against the rules of git check-ref-format, which also accepts commit hashes, and
+// rejects a leading '-'
+func isRevision(revision string) bool {
+	if revision == "@" || strings.HasPrefix(revision, "-") || strings.HasPrefix(revision, "/") ||
+		strings.HasSuffix(revision, "/") || strings.HasSuffix(revision, ".") {
+		return false
+	}
+
+	if strings.Contains(revision, "..") || strings.Contains(revision, "@{") || strings.Contains(revision, "//") {
+		return false
+	}
+
+	for _, r := range revision {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+			return false
+		}
+	}
+
+	for _, component := range strings.Split(revision, "/") {
+		if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resourceToOrtRun converts an OrtRun resource to its API representation. Stage timings are only taken from the status
+// of the resource. Use ortController.ortRun to fill in missing timings from the pods of each stage.
+func resourceToOrtRun(resource *ortv1.OrtRun, withYaml bool) (OrtRun, error) {
 	run := OrtRun{
-		Name:    resource.Object["metadata"].(map[string]interface{})["name"].(string),
-		RepoUrl: resource.Object["spec"].(map[string]interface{})["repoUrl"].(string),
-		Status:  runStatusFromUnstructured(resource),
+		Name:             resource.Name,
+		Namespace:        resource.Namespace,
+		CreatedAt:        resource.CreationTimestamp.Time,
+		RepoUrl:          resource.Spec.RepoUrl,
+		Revision:         resource.Spec.Revision,
+		Path:             resource.Spec.Path,
+		AnalyzerOptions:  resource.Spec.AnalyzerOptions,
+		ScannerOptions:   resource.Spec.ScannerOptions,
+		PackageCurations: resource.Spec.PackageCurations,
+		Labels:           resource.Labels,
+		SourceRun:        resource.Annotations[ortv1.SourceRunAnnotation],
+		CreatedBy:        resource.Annotations[ortv1.CreatedByAnnotation],
+		Status:           runStatusFromResource(resource),
+		Timings: StageTimings{
+			Analyzer: newStageTiming(resource.Status.AnalyzerStartedAt, resource.Status.AnalyzerFinishedAt),
+			Scanner:  newStageTiming(resource.Status.ScannerStartedAt, resource.Status.ScannerFinishedAt),
+			Reporter: newStageTiming(resource.Status.ReporterStartedAt, resource.Status.ReporterFinishedAt),
+		},
 	}
 
+	run.Phase = run.Status.phase()
+	run.Timings.updateDurations(run.Status, time.Now())
+
 	if withYaml {
-		objYaml, err := yaml.Marshal(resource.Object)
+		objYaml, err := yaml.Marshal(resource)
 		if err != nil {
 			return run, err
 		}
@@ -80,21 +291,83 @@ func unstructuredToOrtRun(resource *unstructured.Unstructured, withYaml bool) (O
 	return run, nil
 }
 
-func unstructuredListToOrtRunList(list *unstructured.UnstructuredList) (OrtRunList, error) {
-	runList := OrtRunList{}
-	var runs []OrtRun
+func newStageTiming(startedAt, finishedAt *metav1.Time) StageTiming {
+	timing := StageTiming{}
 
-	for _, item := range list.Items {
-		run, err := unstructuredToOrtRun(&item, false)
-		if err != nil {
-			return runList, err
-		}
+	if startedAt != nil {
+		timing.StartedAt = &startedAt.Time
+	}
+
+	if finishedAt != nil {
+		timing.FinishedAt = &finishedAt.Time
+	}
+
+	return timing
+}
+
+// byStage returns pointers to the timing of each stage, keyed by the stage name
+func (t *StageTimings) byStage() map[string]*StageTiming {
+	return map[string]*StageTiming{
+		"analyzer": &t.Analyzer,
+		"scanner":  &t.Scanner,
+		"reporter": &t.Reporter,
+	}
+}
+
+func (t *StageTimings) updateDurations(status RunStatus, now time.Time) {
+	statuses := status.byStage()
 
-		runs = append(runs, run)
+	for stage, timing := range t.byStage() {
+		timing.updateDuration(statuses[stage], now)
 	}
+}
+
+// updateDuration only counts up to now while the stage is running. Otherwise, e.g. for aborted stages, it would keep
+// growing forever.
+func (t *StageTiming) updateDuration(status StageStatus, now time.Time) {
+	t.DurationSeconds = 0
+
+	if t.StartedAt == nil {
+		return
+	}
+
+	end := now
+	if t.FinishedAt != nil {
+		end = *t.FinishedAt
+	} else if status != Running {
+		return
+	}
+
+	t.DurationSeconds = int64(end.Sub(*t.StartedAt).Seconds())
+}
+
+func (t StageTiming) duration() time.Duration {
+	return time.Duration(t.DurationSeconds) * time.Second
+}
+
+func (rs RunStatus) byStage() map[string]StageStatus {
+	return map[string]StageStatus{
+		"analyzer": rs.Analyzer,
+		"scanner":  rs.Scanner,
+		"reporter": rs.Reporter,
+	}
+}
+
+func (rs RunStatus) phase() RunPhase {
+	statuses := []StageStatus{rs.Analyzer, rs.Scanner, rs.Reporter}
 
-	runList.Runs = runs
-	return runList, nil
+	// a run aborted after one of its stages failed has failed, although its other stages are reported as aborted
+	switch {
+	case containsStatus(statuses, Failed):
+		return PhaseFailed
+	case containsStatus(statuses, Aborted):
+		return PhaseAborted
+	case rs.Reporter == Succeeded:
+		return PhaseSucceeded
+	case rs.Analyzer == Pending && rs.Scanner == Pending && rs.Reporter == Pending:
+		return PhaseQueued
+	}
+	return PhaseRunning
 }
 
 func (s StageStatus) String() string {
@@ -113,35 +386,45 @@ func (s StageStatus) String() string {
 	return "Unknown"
 }
 
-func runStatusFromUnstructured(resource *unstructured.Unstructured) RunStatus {
-	result := RunStatus{Pending, Pending, Pending}
-	statuses, found := resource.Object["status"].(map[string]interface{})
-	if !found {
-		return result
+func runStatusFromResource(resource *ortv1.OrtRun) RunStatus {
+	result := RunStatus{
+		Analyzer: stageStatusFromString(resource.Status.Analyzer),
+		Scanner:  stageStatusFromString(resource.Status.Scanner),
+		Reporter: stageStatusFromString(resource.Status.Reporter),
 	}
 
-	s, found := statuses["analyzer"].(string)
-	if found {
-		result.Analyzer = stageStatusFromString(s)
+	// the operator might not have caught up with an abort request yet, so stages that have not finished are reported as
+	// aborted right away
+	if resource.Spec.Abort {
+		result.Analyzer = result.Analyzer.abort()
+		result.Scanner = result.Scanner.abort()
+		result.Reporter = result.Reporter.abort()
 	}
 
-	s, found = statuses["scanner"].(string)
-	if found {
-		result.Scanner = stageStatusFromString(s)
-	}
+	return result
+}
 
-	s, found = statuses["reporter"].(string)
-	if found {
-		result.Reporter = stageStatusFromString(s)
+func (s StageStatus) abort() StageStatus {
+	if s == Succeeded || s == Failed {
+		return s
 	}
-
-	return result
+	return Aborted
 }
 
 func (s StageStatus) MarshalJSON() ([]byte, error) {
 	return json.Marshal(s.String())
 }
 
+// parseStageStatus is the strict counterpart to stageStatusFromString for parsing user input
+func parseStageStatus(s string) (StageStatus, bool) {
+	for _, status := range []StageStatus{Pending, Running, Succeeded, Failed, Aborted} {
+		if status.String() == s {
+			return status, true
+		}
+	}
+	return Pending, false
+}
+
 func stageStatusFromString(s string) StageStatus {
 	switch s {
 	case "Running":
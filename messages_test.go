@@ -0,0 +1,101 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCreateRunRequestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   CreateRunRequest
+		field string
+	}{
+		{"https URL", CreateRunRequest{RepoUrl: "https://github.com/haikoschol/cats-of-asia.git"}, ""},
+		{"scp-like URL", CreateRunRequest{RepoUrl: "git@github.com:haikoschol/cats-of-asia.git"}, ""},
+		{"missing repoUrl", CreateRunRequest{}, "spec.repoUrl"},
+		{"repoUrl without host", CreateRunRequest{RepoUrl: "cats-of-asia"}, "spec.repoUrl"},
+		{"repoUrl is an option", CreateRunRequest{RepoUrl: "-oProxyCommand=x@host:path"}, "spec.repoUrl"},
+		{"branch", CreateRunRequest{RepoUrl: "git@host:repo", Revision: "feature/cats"}, ""},
+		{"tag", CreateRunRequest{RepoUrl: "git@host:repo", Revision: "v1.2.3"}, ""},
+		{"commit", CreateRunRequest{RepoUrl: "git@host:repo", Revision: "4254734c"}, ""},
+		{"revision is an option", CreateRunRequest{RepoUrl: "git@host:repo", Revision: "--upload-pack=x"}, "spec.revision"},
+		{"revision with whitespace", CreateRunRequest{RepoUrl: "git@host:repo", Revision: "main branch"}, "spec.revision"},
+		{"revision with two dots", CreateRunRequest{RepoUrl: "git@host:repo", Revision: "main..dev"}, "spec.revision"},
+		{"revision with reflog syntax", CreateRunRequest{RepoUrl: "git@host:repo", Revision: "main@{1}"}, "spec.revision"},
+		{"revision with colon", CreateRunRequest{RepoUrl: "git@host:repo", Revision: "a:b"}, "spec.revision"},
+		{"revision ending in .lock", CreateRunRequest{RepoUrl: "git@host:repo", Revision: "main.lock"}, "spec.revision"},
+		{"revision component starting with a dot", CreateRunRequest{RepoUrl: "git@host:repo", Revision: "a/.b"}, "spec.revision"},
+		{"revision ending with a slash", CreateRunRequest{RepoUrl: "git@host:repo", Revision: "main/"}, "spec.revision"},
+		{"revision @", CreateRunRequest{RepoUrl: "git@host:repo", Revision: "@"}, "spec.revision"},
+		{"subdirectory", CreateRunRequest{RepoUrl: "git@host:repo", Path: "services/api"}, ""},
+		{"path is an option", CreateRunRequest{RepoUrl: "git@host:repo", Path: "-rf"}, "spec.path"},
+		{"absolute path", CreateRunRequest{RepoUrl: "git@host:repo", Path: "/etc"}, "spec.path"},
+		{"path outside the repository", CreateRunRequest{RepoUrl: "git@host:repo", Path: "a/../.."}, "spec.path"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.req.validate()
+
+			if tt.field == "" {
+				if len(errs) > 0 {
+					t.Errorf("got errors %v, want none", errs)
+				}
+				return
+			}
+
+			if len(errs) != 1 || errs[0].Field != tt.field {
+				t.Errorf("got errors %v, want one for %s", errs, tt.field)
+			}
+		})
+	}
+}
+
+func TestStageTimingUpdateDuration(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	started := now.Add(-10 * time.Minute)
+	finished := now.Add(-4 * time.Minute)
+
+	tests := []struct {
+		name     string
+		timing   StageTiming
+		status   StageStatus
+		expected int64
+	}{
+		{"pending", StageTiming{}, Pending, 0},
+		{"running", StageTiming{StartedAt: &started}, Running, 600},
+		{"finished", StageTiming{StartedAt: &started, FinishedAt: &finished}, Succeeded, 360},
+		{"failed with known end", StageTiming{StartedAt: &started, FinishedAt: &finished}, Failed, 360},
+		{"aborted with unknown end", StageTiming{StartedAt: &started}, Aborted, 0},
+		{"failed with unknown end", StageTiming{StartedAt: &started}, Failed, 0},
+		{"succeeded with unknown end", StageTiming{StartedAt: &started}, Succeeded, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.timing.DurationSeconds = 42
+			tt.timing.updateDuration(tt.status, now)
+
+			if tt.timing.DurationSeconds != tt.expected {
+				t.Errorf("got %d seconds, want %d", tt.timing.DurationSeconds, tt.expected)
+			}
+		})
+	}
+}
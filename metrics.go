@@ -0,0 +1,279 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	ortv1 "github.com/haikoschol/ort-operator-api/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const metricsNamespace = "ort_api"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by handler, method and status code.",
+	}, []string{"handler", "method", "code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by handler, method and status code. Streams last until they end.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler", "method", "code"})
+
+	kubernetesRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kubernetes_requests_total",
+		Help:      "Requests to the Kubernetes API by cluster, verb, resource and status code, or 'error' if none.",
+	}, []string{"cluster", "verb", "resource", "code"})
+
+	kubernetesRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "kubernetes_request_duration_seconds",
+		Help:      "Duration of requests to the Kubernetes API until the response headers arrived.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"cluster", "verb", "resource"})
+
+	botCommands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "bot_commands_total",
+		Help:      "Commands handled by the chat bots by platform and command.",
+	}, []string{"platform", "command"})
+
+	stageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "stage_duration_seconds",
+		Help:      "Duration of finished stages of OrtRuns by cluster, stage and status.",
+		Buckets:   prometheus.ExponentialBuckets(30, 2, 10),
+	}, []string{"cluster", "stage", "status"})
+
+	runsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "ortruns"),
+		"OrtRuns by cluster, namespace, stage and status of the stage.",
+		[]string{"cluster", "namespace", "stage", "status"},
+		nil,
+	)
+)
+
+// stageStatuses are all values of StageStatus, so the gauges of OrtRuns are reported as 0 instead of being absent
+var stageStatuses = []StageStatus{Pending, Running, Succeeded, Failed, Aborted}
+
+// instrumentHandler counts the requests to handler and measures their duration, labelled with name
+func instrumentHandler(name string, handler http.Handler) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+
+	return promhttp.InstrumentHandlerDuration(
+		httpRequestDuration.MustCurryWith(labels),
+		promhttp.InstrumentHandlerCounter(httpRequests.MustCurryWith(labels), handler),
+	)
+}
+
+// kubernetesTransport counts the requests of an ortController to the Kubernetes API and measures their latency
+type kubernetesTransport struct {
+	cluster string
+	next    http.RoundTripper
+}
+
+func (kt kubernetesTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	verb, resource := kubernetesVerbAndResource(req)
+	start := time.Now()
+
+	resp, err := kt.next.RoundTrip(req)
+
+	kubernetesRequestDuration.WithLabelValues(kt.cluster, verb, resource).Observe(time.Since(start).Seconds())
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	kubernetesRequests.WithLabelValues(kt.cluster, verb, resource, code).Inc()
+
+	return resp, err
+}
+
+// kubernetesVerbAndResource derives the Kubernetes verb, e.g. "list", and the resource, e.g. "pods" or "pods/log",
+// from the request. Requests to anything but resources result in the verb and resource "other".
+func kubernetesVerbAndResource(req *http.Request) (string, string) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) >= 3 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 4 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return "other", "other"
+	}
+
+	if len(parts) > 2 && parts[0] == "namespaces" {
+		parts = parts[2:]
+	}
+
+	resource := parts[0]
+	if len(parts) > 2 {
+		resource += "/" + parts[2]
+	}
+
+	verbs := map[string]string{
+		http.MethodPost:   "create",
+		http.MethodPut:    "update",
+		http.MethodPatch:  "patch",
+		http.MethodDelete: "delete",
+	}
+
+	verb, found := verbs[req.Method]
+	switch {
+	case found:
+	case req.URL.Query().Get("watch") == "true":
+		verb = "watch"
+	case len(parts) == 1:
+		verb = "list"
+	default:
+		verb = "get"
+	}
+
+	return verb, resource
+}
+
+// countBotCommand counts a command handled by the bot of the given platform
+func countBotCommand(platform, command string) {
+	botCommands.WithLabelValues(platform, botCommandName(command)).Inc()
+}
+
+// botCommandName returns the name of a bot command for metrics and spans. Unknown commands are named "unknown", so
+// arbitrary input does not create new time series.
+func botCommandName(command string) string {
+	if _, found := commandPermissions[command]; !found && command != "help" {
+		return "unknown"
+	}
+	return command
+}
+
+// stageDurationObserver returns the event handler that observes the duration of each stage of an OrtRun that finishes
+// while the informer of oc watches it. OrtRuns that are already finished when the informer starts are not observed.
+func (oc ortController) stageDurationObserver() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldRun, err := ortRunFromObject(oldObj.(runtime.Object))
+			if err != nil {
+				return
+			}
+
+			newRun, err := ortRunFromObject(newObj.(runtime.Object))
+			if err != nil {
+				return
+			}
+
+			oc.observeStageDurations(oldRun, newRun)
+		},
+	}
+}
+
+func (oc ortController) observeStageDurations(oldRun, newRun *ortv1.OrtRun) {
+	before := runStatusFromResource(oldRun).byStage()
+	after := runStatusFromResource(newRun).byStage()
+	var timings map[string]*StageTiming
+
+	for _, stage := range stages {
+		status := after[stage]
+		if status == before[stage] || (status != Succeeded && status != Failed) {
+			continue
+		}
+
+		if timings == nil {
+			// the pods are read from the informer cache, so this does not call the API server
+			run, err := oc.ortRun(context.Background(), newRun, false)
+			if err != nil {
+				slog.Error("failed to observe stage durations", "cluster", oc.cluster, "run", newRun.Name, "error", err)
+				return
+			}
+			timings = run.Timings.byStage()
+		}
+
+		timing := timings[stage]
+		if timing.StartedAt != nil && timing.FinishedAt != nil {
+			seconds := timing.FinishedAt.Sub(*timing.StartedAt).Seconds()
+			stageDuration.WithLabelValues(oc.cluster, stage, status.String()).Observe(seconds)
+		}
+	}
+}
+
+// runCollector reports the number of OrtRuns in the informer caches of all clusters by stage and status
+type runCollector struct {
+	clusters clusters
+}
+
+func (rc runCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- runsDesc
+}
+
+func (rc runCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, cluster := range rc.clusters.names {
+		oc := rc.clusters.controllers[cluster]
+
+		runs, err := oc.listRunsInAllNamespaces(context.Background(), labels.Everything())
+		if err != nil {
+			slog.Error("failed to count runs", "cluster", cluster, "error", err)
+			continue
+		}
+
+		counts := map[string]map[string]map[StageStatus]int{}
+		for _, ns := range oc.namespaces.list() {
+			counts[ns] = map[string]map[StageStatus]int{}
+			for _, stage := range stages {
+				counts[ns][stage] = map[StageStatus]int{}
+			}
+		}
+
+		for i := range runs.Items {
+			run := &runs.Items[i]
+			if counts[run.Namespace] == nil {
+				continue
+			}
+
+			for stage, status := range runStatusFromResource(run).byStage() {
+				counts[run.Namespace][stage][status]++
+			}
+		}
+
+		for ns, byStage := range counts {
+			for stage, byStatus := range byStage {
+				for _, status := range stageStatuses {
+					ch <- prometheus.MustNewConstMetric(
+						runsDesc,
+						prometheus.GaugeValue,
+						float64(byStatus[status]),
+						cluster, ns, stage, status.String(),
+					)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// namespaceSyncTimeout limits how long the caches of a namespace may take to fill, on startup and when it is added at
+// runtime. If they don't fill in time, e.g. because the API server can not be reached or the API lacks permissions in
+// the namespace, startup fails or the namespace is not added.
+const namespaceSyncTimeout = time.Minute
+
+// namespaceClients are the clients for the OrtRuns and pods in a single namespace. Clients without informer caches have
+// neither pods nor stop.
+type namespaceClients struct {
+	runs         ortRunClient
+	pods         corelisters.PodNamespaceLister
+	podInformers informers.SharedInformerFactory
+	synced       []cache.InformerSynced
+	stop         chan struct{}
+}
+
+func newCachedNamespaceClients(
+	dynClient dynamic.Interface,
+	clientset kubernetes.Interface,
+	namespace string,
+) namespaceClients {
+	runs := newOrtRunClient(dynClient, namespace)
+	podInformers := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace))
+	podInformer := podInformers.Core().V1().Pods()
+
+	return namespaceClients{
+		runs:         runs,
+		pods:         podInformer.Lister().Pods(namespace),
+		podInformers: podInformers,
+		synced:       []cache.InformerSynced{runs.informer.HasSynced, podInformer.Informer().HasSynced},
+		stop:         make(chan struct{}),
+	}
+}
+
+func newUncachedNamespaceClients(dynClient dynamic.Interface, namespace string) namespaceClients {
+	return namespaceClients{runs: newUncachedOrtRunClient(dynClient, namespace)}
+}
+
+// newNamespaceClients creates cached clients for the namespace that observe the durations of the stages of its OrtRuns
+func (oc ortController) newNamespaceClients(namespace string) namespaceClients {
+	nc := newCachedNamespaceClients(oc.dynClient, oc.clientset, namespace)
+
+	if _, err := nc.runs.informer.AddEventHandler(oc.stageDurationObserver()); err != nil {
+		slog.Error("failed to observe stage durations", "cluster", oc.cluster, "namespace", namespace, "error", err)
+	}
+
+	return nc
+}
+
+// start runs the informers until the clients are stopped
+func (nc namespaceClients) start() {
+	nc.runs.start(nc.stop)
+	nc.podInformers.Start(nc.stop)
+}
+
+// namespaceSet holds the clients for the allowed namespaces of an ortController. Since the allowlist can change at
+// runtime, the set is shared between all copies of a controller. Namespaces added at runtime are pending until their
+// caches are filled and are only added if they are still in the allowlist by then.
+type namespaceSet struct {
+	mu      *sync.RWMutex
+	names   []string
+	clients map[string]namespaceClients
+	allowed map[string]bool
+	pending map[string]bool
+}
+
+func newNamespaceSet() *namespaceSet {
+	return &namespaceSet{mu: &sync.RWMutex{}, clients: map[string]namespaceClients{}, pending: map[string]bool{}}
+}
+
+func (s *namespaceSet) get(namespace string) (namespaceClients, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nc, found := s.clients[namespace]
+	return nc, found
+}
+
+// list returns the names of all namespaces in the order they were added
+func (s *namespaceSet) list() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]string(nil), s.names...)
+}
+
+func (s *namespaceSet) add(namespace string, nc namespaceClients) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, found := s.clients[namespace]; !found {
+		s.names = append(s.names, namespace)
+	}
+	s.clients[namespace] = nc
+}
+
+// remove removes the namespace from the set and stops its informers
+func (s *namespaceSet) remove(namespace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nc, found := s.clients[namespace]
+	if !found {
+		return
+	}
+
+	delete(s.clients, namespace)
+	for i, name := range s.names {
+		if name == namespace {
+			s.names = append(s.names[:i], s.names[i+1:]...)
+			break
+		}
+	}
+
+	if nc.stop != nil {
+		close(nc.stop)
+	}
+}
+
+// setAllowed replaces the allowlist that pending namespaces are checked against once their caches are filled
+func (s *namespaceSet) setAllowed(allowed map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.allowed = allowed
+}
+
+// startPending marks the namespace as pending and returns true, unless it is already in the set or pending
+func (s *namespaceSet) startPending(namespace string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, found := s.clients[namespace]; found || s.pending[namespace] {
+		return false
+	}
+
+	s.pending[namespace] = true
+	return true
+}
+
+// addPending adds the pending namespace to the set if it is still allowed. Otherwise, it stops its informers and
+// returns false.
+func (s *namespaceSet) addPending(namespace string, nc namespaceClients) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.pending, namespace)
+
+	if !s.allowed[namespace] {
+		close(nc.stop)
+		return false
+	}
+
+	s.names = append(s.names, namespace)
+	s.clients[namespace] = nc
+	return true
+}
+
+// dropPending removes the namespace from the pending ones after its caches failed to fill
+func (s *namespaceSet) dropPending(namespace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.pending, namespace)
+}
+
+// stop stops the informers of all namespaces in the set. Namespaces still pending are stopped once their caches are
+// filled.
+func (s *namespaceSet) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.allowed = nil
+
+	for ns, nc := range s.clients {
+		if nc.stop != nil {
+			close(nc.stop)
+			nc.stop = nil
+			s.clients[ns] = nc
+		}
+	}
+}
+
+// setAllowedNamespaces changes the allowlist of the controller at runtime. Namespaces that are no longer allowed are
+// removed right away. New namespaces are only added once their informer caches are filled, which happens in the
+// background, and if a later change did not remove them from the allowlist in the meantime. The default namespace can
+// not be removed.
+func (oc ortController) setAllowedNamespaces(allowed []string) {
+	wanted := map[string]bool{oc.defaultNamespace: true}
+	for _, ns := range allowed {
+		wanted[ns] = true
+	}
+
+	oc.namespaces.setAllowed(wanted)
+
+	for _, ns := range oc.namespaces.list() {
+		if !wanted[ns] {
+			oc.namespaces.remove(ns)
+			slog.Info("removed namespace", "cluster", oc.cluster, "namespace", ns)
+		}
+	}
+
+	for ns := range wanted {
+		if !oc.namespaces.startPending(ns) {
+			continue
+		}
+
+		nc := oc.newNamespaceClients(ns)
+		nc.start()
+
+		go func(ns string) {
+			if !waitForCacheSync(namespaceSyncTimeout, nc.synced...) {
+				close(nc.stop)
+				oc.namespaces.dropPending(ns)
+				slog.Error("failed to add namespace, the informer caches did not sync", "cluster", oc.cluster, "namespace", ns)
+				return
+			}
+
+			if !oc.namespaces.addPending(ns, nc) {
+				slog.Info("did not add namespace, it was removed from the allowlist", "cluster", oc.cluster, "namespace", ns)
+				return
+			}
+
+			slog.Info("added namespace", "cluster", oc.cluster, "namespace", ns)
+		}(ns)
+	}
+}
+
+// waitForCacheSync waits at most timeout for the caches to be filled and returns whether they were
+func waitForCacheSync(timeout time.Duration, synced ...cache.InformerSynced) bool {
+	expired := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(expired) })
+	defer timer.Stop()
+
+	return cache.WaitForCacheSync(expired, synced...)
+}
@@ -0,0 +1,267 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwtLeeway is the clock skew tolerated when checking the expiry and not-before times of a token
+	jwtLeeway = time.Minute
+	// jwksMinRefreshInterval limits how often a remote JWKS is fetched again because a token refers to an unknown key
+	jwksMinRefreshInterval = time.Minute
+	jwksFetchTimeout       = 10 * time.Second
+)
+
+// jwtAlgorithms are the signature algorithms accepted for JWTs. Symmetric algorithms are left out on purpose, since the
+// keys in a JWKS are public.
+var jwtAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// jwtAuthenticator validates JWTs issued by an OIDC identity provider with the keys from its JWKS. Tokens of other
+// issuers are not recognized, so that for example ServiceAccount tokens are left to the next authenticator.
+type jwtAuthenticator struct {
+	issuer      string
+	audience    string
+	groupsClaim string
+	keys        *jwks
+}
+
+func newJwtAuthenticator(cfg oidcConfig) (jwtAuthenticator, error) {
+	keys := &jwks{location: cfg.Jwks, mu: &sync.Mutex{}}
+	if err := keys.load(); err != nil {
+		return jwtAuthenticator{}, err
+	}
+
+	return jwtAuthenticator{
+		issuer:      cfg.Issuer,
+		audience:    cfg.Audience,
+		groupsClaim: cfg.GroupsClaim,
+		keys:        keys,
+	}, nil
+}
+
+func (ja jwtAuthenticator) authenticate(ctx context.Context, token string) (identity, bool, error) {
+	tok, err := jwt.ParseSigned(token)
+	if err != nil {
+		return identity{}, false, nil
+	}
+
+	unverified := jwt.Claims{}
+	if err := tok.UnsafeClaimsWithoutVerification(&unverified); err != nil || unverified.Issuer != ja.issuer {
+		return identity{}, false, nil
+	}
+
+	id, err := ja.verify(tok)
+	if err != nil {
+		loggerFrom(ctx).Warn("rejecting JWT", "subject", unverified.Subject, "error", err)
+		return identity{}, false, nil
+	}
+
+	return id, true, nil
+}
+
+func (ja jwtAuthenticator) verify(tok *jwt.JSONWebToken) (identity, error) {
+	if len(tok.Headers) != 1 {
+		return identity{}, errors.New("expected exactly one signature")
+	}
+
+	header := tok.Headers[0]
+	if !isJwtAlgorithm(header.Algorithm) {
+		return identity{}, fmt.Errorf("signature algorithm '%s' is not allowed", header.Algorithm)
+	}
+
+	key, found := ja.keys.key(header.KeyID)
+	if !found {
+		return identity{}, fmt.Errorf("unknown key '%s'", header.KeyID)
+	}
+
+	claims := jwt.Claims{}
+	extra := map[string]interface{}{}
+	if err := tok.Claims(key, &claims, &extra); err != nil {
+		return identity{}, err
+	}
+
+	if claims.Expiry == nil {
+		return identity{}, errors.New("missing expiry")
+	}
+
+	if claims.Subject == "" {
+		return identity{}, errors.New("missing subject")
+	}
+
+	expected := jwt.Expected{Issuer: ja.issuer, Time: time.Now()}
+	if ja.audience != "" {
+		expected.Audience = jwt.Audience{ja.audience}
+	}
+
+	if err := claims.ValidateWithLeeway(expected, jwtLeeway); err != nil {
+		return identity{}, err
+	}
+
+	groups, err := groupsFromClaim(extra[ja.groupsClaim])
+	if err != nil {
+		return identity{}, fmt.Errorf("invalid claim '%s': %w", ja.groupsClaim, err)
+	}
+
+	return identity{name: claims.Subject, groups: groups}, nil
+}
+
+// groupsFromClaim accepts a list of strings or a single string, since identity providers differ in how they encode a
+// single group
+func groupsFromClaim(claim interface{}) ([]string, error) {
+	switch value := claim.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{value}, nil
+	case []interface{}:
+		groups := make([]string, 0, len(value))
+		for _, v := range value {
+			group, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string, got %T", v)
+			}
+			groups = append(groups, group)
+		}
+		return groups, nil
+	default:
+		return nil, fmt.Errorf("expected a list of strings, got %T", claim)
+	}
+}
+
+func isJwtAlgorithm(name string) bool {
+	for _, alg := range jwtAlgorithms {
+		if string(alg) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// jwks holds the keys of a JWKS loaded from a URL or a local file. Keys fetched from a URL are fetched again when a
+// token refers to an unknown key, e.g. after the identity provider rotated its keys.
+type jwks struct {
+	location  string
+	mu        *sync.Mutex
+	keys      jose.JSONWebKeySet
+	fetchedAt time.Time
+}
+
+func (j *jwks) isRemote() bool {
+	return strings.HasPrefix(j.location, "https://") || strings.HasPrefix(j.location, "http://")
+}
+
+// load reads the JWKS from its location. The caller must not hold the lock.
+func (j *jwks) load() error {
+	data, err := j.read()
+	if err != nil {
+		return fmt.Errorf("failed to load JWKS from '%s': %w", j.location, err)
+	}
+
+	keys := jose.JSONWebKeySet{}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("failed to parse JWKS from '%s': %w", j.location, err)
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.fetchedAt = time.Now()
+	j.mu.Unlock()
+
+	return nil
+}
+
+func (j *jwks) read() ([]byte, error) {
+	if !j.isRemote() {
+		return os.ReadFile(j.location)
+	}
+
+	client := http.Client{Timeout: jwksFetchTimeout}
+	resp, err := client.Get(j.location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// key returns the key with the given id. If keyId is empty, the JWKS must contain only a single key.
+func (j *jwks) key(keyId string) (jose.JSONWebKey, bool) {
+	if key, found := j.lookup(keyId); found {
+		return key, true
+	}
+
+	j.mu.Lock()
+	refresh := j.isRemote() && time.Since(j.fetchedAt) > jwksMinRefreshInterval
+	if refresh {
+		// keeps concurrent requests from fetching the JWKS at the same time
+		j.fetchedAt = time.Now()
+	}
+	j.mu.Unlock()
+
+	if !refresh {
+		return jose.JSONWebKey{}, false
+	}
+
+	if err := j.load(); err != nil {
+		slog.Error("failed to fetch the JWKS", "jwks", j.location, "error", err)
+		return jose.JSONWebKey{}, false
+	}
+
+	return j.lookup(keyId)
+}
+
+func (j *jwks) lookup(keyId string) (jose.JSONWebKey, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if keyId == "" {
+		if len(j.keys.Keys) == 1 {
+			return j.keys.Keys[0], true
+		}
+		return jose.JSONWebKey{}, false
+	}
+
+	keys := j.keys.Key(keyId)
+	if len(keys) == 0 {
+		return jose.JSONWebKey{}, false
+	}
+	return keys[0], true
+}
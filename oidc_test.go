@@ -0,0 +1,421 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testIssuer = "https://idp.example.com"
+
+// testKeys are the private keys of a JWKS generated for the tests, keyed by their IDs
+type testKeys map[string]interface{}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testKeys{"rsa": rsaKey, "ec": ecKey, "hmac": []byte("0123456789abcdef0123456789abcdef")}
+}
+
+// jwks returns the JWKS with the public keys of the given IDs. Symmetric keys are included as they are, like a
+// misconfigured identity provider would publish them.
+func (tk testKeys) jwks(t *testing.T, keyIds ...string) []byte {
+	t.Helper()
+
+	set := jose.JSONWebKeySet{}
+	for _, kid := range keyIds {
+		key := jose.JSONWebKey{Key: tk[kid], KeyID: kid, Use: "sig"}
+		if !key.IsPublic() && key.Valid() {
+			if public := key.Public(); public.Valid() {
+				key = public
+			}
+		}
+		set.Keys = append(set.Keys, key)
+	}
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// sign returns a JWT with the claims signed with the key of the given ID
+func (tk testKeys) sign(t *testing.T, alg jose.SignatureAlgorithm, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	return tk.signAs(t, alg, kid, kid, claims)
+}
+
+// signAs returns a JWT with the claims signed with the key of the given ID and a header naming another key
+func (tk testKeys) signAs(
+	t *testing.T,
+	alg jose.SignatureAlgorithm,
+	kid string,
+	headerKid string,
+	claims map[string]interface{},
+) string {
+	t.Helper()
+
+	opts := (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", headerKid)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: tk[kid]}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func writeJwks(t *testing.T, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func validClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss": testIssuer,
+		"sub": "alice",
+		"aud": "ort-operator-api",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+}
+
+func TestJwtAuthenticator(t *testing.T) {
+	keys := newTestKeys(t)
+	jwksPath := writeJwks(t, keys.jwks(t, "rsa", "ec", "hmac"))
+	now := time.Now()
+
+	// with modifies the valid claims
+	with := func(changes map[string]interface{}) map[string]interface{} {
+		claims := validClaims()
+		for name, value := range changes {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name     string
+		audience string
+		alg      jose.SignatureAlgorithm
+		kid      string
+		// headerKid is the key named in the header, if it is not the one the token is signed with
+		headerKid string
+		claims    map[string]interface{}
+		wantOk    bool
+		wantId    identity
+	}{
+		{
+			name:   "RS256",
+			alg:    jose.RS256,
+			kid:    "rsa",
+			claims: validClaims(),
+			wantOk: true,
+			wantId: identity{name: "alice"},
+		},
+		{name: "PS512", alg: jose.PS512, kid: "rsa", claims: validClaims(), wantOk: true, wantId: identity{name: "alice"}},
+		{name: "ES256", alg: jose.ES256, kid: "ec", claims: validClaims(), wantOk: true, wantId: identity{name: "alice"}},
+		{name: "HS256 with a key from the JWKS", alg: jose.HS256, kid: "hmac", claims: validClaims()},
+		{name: "signed with another key", alg: jose.RS256, kid: "rsa", headerKid: "ec", claims: validClaims()},
+		{name: "unknown key", alg: jose.RS256, kid: "rsa", headerKid: "other", claims: validClaims()},
+		{name: "other issuer", alg: jose.RS256, kid: "rsa", claims: with(map[string]interface{}{"iss": "https://evil"})},
+		{name: "missing issuer", alg: jose.RS256, kid: "rsa", claims: with(map[string]interface{}{"iss": nil})},
+		{
+			name:     "expected audience",
+			audience: "ort-operator-api",
+			alg:      jose.RS256,
+			kid:      "rsa",
+			claims:   validClaims(),
+			wantOk:   true,
+			wantId:   identity{name: "alice"},
+		},
+		{
+			name:     "expected audience among others",
+			audience: "ort-operator-api",
+			alg:      jose.RS256,
+			kid:      "rsa",
+			claims:   with(map[string]interface{}{"aud": []string{"other", "ort-operator-api"}}),
+			wantOk:   true,
+			wantId:   identity{name: "alice"},
+		},
+		{
+			name:     "other audience",
+			audience: "ort-operator-api",
+			alg:      jose.RS256,
+			kid:      "rsa",
+			claims:   with(map[string]interface{}{"aud": "other"}),
+		},
+		{
+			name:     "missing audience",
+			audience: "ort-operator-api",
+			alg:      jose.RS256,
+			kid:      "rsa",
+			claims:   with(map[string]interface{}{"aud": nil}),
+		},
+		{
+			name:   "any audience without audience configured",
+			alg:    jose.RS256,
+			kid:    "rsa",
+			claims: with(map[string]interface{}{"aud": "other"}),
+			wantOk: true,
+			wantId: identity{name: "alice"},
+		},
+		{
+			name:   "expired within the leeway",
+			alg:    jose.RS256,
+			kid:    "rsa",
+			claims: with(map[string]interface{}{"exp": now.Add(-jwtLeeway / 2).Unix()}),
+			wantOk: true,
+			wantId: identity{name: "alice"},
+		},
+		{
+			name:   "expired beyond the leeway",
+			alg:    jose.RS256,
+			kid:    "rsa",
+			claims: with(map[string]interface{}{"exp": now.Add(-2 * jwtLeeway).Unix()}),
+		},
+		{name: "missing expiry", alg: jose.RS256, kid: "rsa", claims: with(map[string]interface{}{"exp": nil})},
+		{
+			name:   "not valid yet within the leeway",
+			alg:    jose.RS256,
+			kid:    "rsa",
+			claims: with(map[string]interface{}{"nbf": now.Add(jwtLeeway / 2).Unix()}),
+			wantOk: true,
+			wantId: identity{name: "alice"},
+		},
+		{
+			name:   "not valid yet beyond the leeway",
+			alg:    jose.RS256,
+			kid:    "rsa",
+			claims: with(map[string]interface{}{"nbf": now.Add(2 * jwtLeeway).Unix()}),
+		},
+		{name: "missing subject", alg: jose.RS256, kid: "rsa", claims: with(map[string]interface{}{"sub": nil})},
+		{
+			name:   "groups as a list",
+			alg:    jose.RS256,
+			kid:    "rsa",
+			claims: with(map[string]interface{}{"groups": []string{"dev", "ops"}}),
+			wantOk: true,
+			wantId: identity{name: "alice", groups: []string{"dev", "ops"}},
+		},
+		{
+			name:   "groups as a string",
+			alg:    jose.RS256,
+			kid:    "rsa",
+			claims: with(map[string]interface{}{"groups": "dev"}),
+			wantOk: true,
+			wantId: identity{name: "alice", groups: []string{"dev"}},
+		},
+		{
+			name:   "groups as a number",
+			alg:    jose.RS256,
+			kid:    "rsa",
+			claims: with(map[string]interface{}{"groups": 42}),
+		},
+		{
+			name:   "groups with a number",
+			alg:    jose.RS256,
+			kid:    "rsa",
+			claims: with(map[string]interface{}{"groups": []interface{}{"dev", 42}}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ja, err := newJwtAuthenticator(oidcConfig{
+				Issuer:      testIssuer,
+				Jwks:        jwksPath,
+				Audience:    tt.audience,
+				GroupsClaim: "groups",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			headerKid := tt.headerKid
+			if headerKid == "" {
+				headerKid = tt.kid
+			}
+
+			id, ok, err := ja.authenticate(context.Background(), keys.signAs(t, tt.alg, tt.kid, headerKid, tt.claims))
+			if err != nil {
+				t.Fatalf("authenticate() failed: %v", err)
+			}
+
+			if ok != tt.wantOk {
+				t.Fatalf("authenticate() = %v, want %v", ok, tt.wantOk)
+			}
+
+			if ok && !reflect.DeepEqual(id, tt.wantId) {
+				t.Errorf("authenticate() = %+v, want %+v", id, tt.wantId)
+			}
+		})
+	}
+}
+
+func TestJwtAuthenticatorRejectsUnsignedTokens(t *testing.T) {
+	keys := newTestKeys(t)
+	ja, err := newJwtAuthenticator(oidcConfig{Issuer: testIssuer, Jwks: writeJwks(t, keys.jwks(t, "rsa"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	token := encode(map[string]string{"alg": "none", "typ": "JWT"}) + "." + encode(validClaims()) + "."
+
+	if _, ok, _ := ja.authenticate(context.Background(), token); ok {
+		t.Error("authenticate() accepted a token with the algorithm 'none'")
+	}
+
+	// a valid signature of the RSA key with the header swapped for one naming no algorithm the keys are meant for
+	signed := keys.sign(t, jose.RS256, "rsa", validClaims())
+	parts := strings.Split(signed, ".")
+	forged := encode(map[string]string{"alg": "HS256", "kid": "rsa"}) + "." + parts[1] + "." + parts[2]
+
+	if _, ok, _ := ja.authenticate(context.Background(), forged); ok {
+		t.Error("authenticate() accepted a token with a forged header")
+	}
+}
+
+func TestJwksRefreshesUnknownKeys(t *testing.T) {
+	keys := newTestKeys(t)
+
+	var served atomic.Pointer[[]byte]
+	var fetches atomic.Int32
+	initial := keys.jwks(t, "rsa")
+	served.Store(&initial)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write(*served.Load())
+	}))
+	defer server.Close()
+
+	ja, err := newJwtAuthenticator(oidcConfig{Issuer: testIssuer, Jwks: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the identity provider rotates its keys
+	rotated := keys.jwks(t, "rsa", "ec")
+	served.Store(&rotated)
+	token := keys.sign(t, jose.ES256, "ec", validClaims())
+
+	if _, ok, _ := ja.authenticate(context.Background(), token); ok {
+		t.Fatal("authenticate() accepted a token before the JWKS could be fetched again")
+	}
+
+	if got := fetches.Load(); got != 1 {
+		t.Fatalf("the JWKS was fetched %d times within jwksMinRefreshInterval, want 1", got)
+	}
+
+	ja.keys.mu.Lock()
+	ja.keys.fetchedAt = time.Now().Add(-2 * jwksMinRefreshInterval)
+	ja.keys.mu.Unlock()
+
+	if _, ok, _ := ja.authenticate(context.Background(), token); !ok {
+		t.Fatal("authenticate() rejected a token signed with a rotated key")
+	}
+
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("the JWKS was fetched %d times, want 2", got)
+	}
+
+	// keys that are still unknown after the refresh are not fetched again right away
+	if _, ok, _ := ja.authenticate(context.Background(), keys.signAs(t, jose.RS256, "rsa", "other", validClaims())); ok {
+		t.Error("authenticate() accepted a token signed with an unknown key")
+	}
+
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("the JWKS was fetched %d times, want 2", got)
+	}
+}
+
+func TestGroupsFromClaim(t *testing.T) {
+	tests := []struct {
+		name    string
+		claim   interface{}
+		want    []string
+		wantErr bool
+	}{
+		{name: "missing", claim: nil, want: nil},
+		{name: "string", claim: "dev", want: []string{"dev"}},
+		{name: "list", claim: []interface{}{"dev", "ops"}, want: []string{"dev", "ops"}},
+		{name: "empty list", claim: []interface{}{}, want: []string{}},
+		{name: "list with a number", claim: []interface{}{"dev", 1.0}, wantErr: true},
+		{name: "number", claim: 1.0, wantErr: true},
+		{name: "object", claim: map[string]interface{}{"dev": true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := groupsFromClaim(tt.claim)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("groupsFromClaim(%v) error = %v, wantErr %v", tt.claim, err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groupsFromClaim(%v) = %#v, want %#v", tt.claim, got, tt.want)
+			}
+		})
+	}
+}
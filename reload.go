@@ -0,0 +1,270 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// reloadInterval is how often the config file and the files it refers to are checked for changes
+const reloadInterval = 2 * time.Second
+
+// liveConfig holds the settings that can change while the server runs: the authenticators, the policy, the namespace
+// allowlist, the report URL template, the access token of the Matrix bot, the channel of the Slack bot and the log
+// level. The API and the bots read them for every request or command, so a reload neither drops connections nor the
+// Matrix sync loop.
+type liveConfig struct {
+	// cfg is the config in effect. It is only accessed by the goroutine running watch.
+	cfg            config
+	clusters       clusters
+	authenticators *atomic.Pointer[[]authenticator]
+	policy         *atomic.Pointer[policy]
+	reportUrl      *atomic.Pointer[string]
+	matrixBot      *matrixBot
+	slackBot       *slackBot
+}
+
+func newLiveConfig(ctx context.Context, cfg config, cl clusters) (*liveConfig, error) {
+	lc := &liveConfig{
+		cfg:            cfg,
+		clusters:       cl,
+		authenticators: &atomic.Pointer[[]authenticator]{},
+		policy:         &atomic.Pointer[policy]{},
+		reportUrl:      &atomic.Pointer[string]{},
+	}
+
+	pol, authenticators, err := lc.load(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	lc.policy.Store(pol)
+	lc.authenticators.Store(&authenticators)
+	lc.reportUrl.Store(&cfg.ReportUrl)
+	return lc, nil
+}
+
+// load reads the policy and creates the authenticators of cfg
+func (lc *liveConfig) load(ctx context.Context, cfg config) (*policy, []authenticator, error) {
+	var pol *policy
+	var err error
+
+	if cfg.Auth.PolicyFile != "" {
+		if pol, err = loadPolicy(cfg.Auth.PolicyFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	authenticators, err := newAuthenticators(ctx, lc.clusters.defaultController(), cfg.Auth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pol, authenticators, nil
+}
+
+// watch reloads the config on SIGHUP and whenever the config file, the API keys file or the policy file change, until
+// ctx is done
+func (lc *liveConfig) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	fingerprint := lc.cfg.fileFingerprint()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("reloading config", "reason", "SIGHUP")
+			lc.reload(ctx)
+		case <-ticker.C:
+			if lc.cfg.fileFingerprint() == fingerprint {
+				continue
+			}
+			slog.Info("reloading config", "reason", "files changed")
+			lc.reload(ctx)
+		}
+
+		// also taken after a rejected reload, so invalid files are not reported again until they change
+		fingerprint = lc.cfg.fileFingerprint()
+	}
+}
+
+// reload reads the config again and applies it. If the new config is invalid, the current one stays in effect.
+// Settings that can only change with a restart keep their current values.
+func (lc *liveConfig) reload(ctx context.Context) {
+	next, err := readConfig(os.Args[1:])
+	if err != nil {
+		slog.Error("keeping the current config", "error", err)
+		return
+	}
+
+	next, ignored := keepRestartSettings(lc.cfg, next)
+	if len(ignored) > 0 {
+		slog.Warn("some changes only take effect after a restart", "settings", ignored)
+	}
+
+	if err := next.validate(); err != nil {
+		slog.Error("keeping the current config, the new one is invalid", "error", err)
+		return
+	}
+
+	pol, authenticators, err := lc.load(ctx, next)
+	if err != nil {
+		slog.Error("keeping the current config", "error", err)
+		return
+	}
+
+	changed := changedSettings(lc.cfg, next)
+
+	lc.policy.Store(pol)
+	lc.authenticators.Store(&authenticators)
+	lc.reportUrl.Store(&next.ReportUrl)
+	// the level was validated along with the rest of the config
+	_ = logLevel.UnmarshalText([]byte(next.LogLevel))
+
+	if len(authenticators) == 0 {
+		slog.Warn("no authentication configured, the API is open to anyone who can reach it")
+	}
+
+	lc.clusters.setAllowedNamespaces(next.Namespaces)
+
+	if lc.matrixBot != nil && next.Matrix.AccessToken != lc.cfg.Matrix.AccessToken {
+		if err := lc.matrixBot.setAccessToken(next.Matrix.AccessToken); err != nil {
+			slog.Error("failed to change the access token of the Matrix bot", "platform", "matrix", "error", err)
+		}
+	}
+
+	if lc.slackBot != nil {
+		lc.slackBot.channelId.Store(&next.Slack.ChannelId)
+	}
+
+	lc.cfg = next
+
+	if len(changed) == 0 {
+		slog.Info("config reloaded, no settings changed")
+		return
+	}
+	slog.Info("config reloaded", "changed", changed)
+}
+
+// keepRestartSettings returns next with the settings that can not change at runtime set to their values in current,
+// along with the names of those that differ
+func keepRestartSettings(current, next config) (config, []string) {
+	kept := next
+	kept.Listen = current.Listen
+	kept.TLS = current.TLS
+	kept.Namespace = current.Namespace
+	kept.Kubeconfig = current.Kubeconfig
+	kept.Context = current.Context
+	kept.Clusters = current.Clusters
+	kept.Impersonation = current.Impersonation
+	kept.Matrix.Server = current.Matrix.Server
+	kept.Matrix.User = current.Matrix.User
+	kept.Slack.Enabled = current.Slack.Enabled
+	kept.Slack.Token = current.Slack.Token
+	kept.Tracing = current.Tracing
+
+	return kept, changedSettings(kept, next)
+}
+
+// changedSettings returns the sorted names of the settings that differ between a and b, e.g. "auth.oidc.issuer". Only
+// the names are returned, since the values can be secrets.
+func changedSettings(a, b config) []string {
+	flatA := flattenConfig(a)
+	flatB := flattenConfig(b)
+
+	var changed []string
+	for name, value := range flatA {
+		if flatB[name] != value {
+			changed = append(changed, name)
+		}
+	}
+
+	for name := range flatB {
+		if _, found := flatA[name]; !found {
+			changed = append(changed, name)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed
+}
+
+// flattenConfig maps the dotted name of each setting of c to its value encoded as JSON. Lists are a single setting.
+func flattenConfig(c config) map[string]string {
+	flat := map[string]string{}
+
+	var tree map[string]interface{}
+	data, _ := json.Marshal(c)
+	_ = json.Unmarshal(data, &tree)
+
+	var walk func(name string, value interface{})
+	walk = func(name string, value interface{}) {
+		if children, ok := value.(map[string]interface{}); ok {
+			for key, child := range children {
+				if name != "" {
+					key = name + "." + key
+				}
+				walk(key, child)
+			}
+			return
+		}
+
+		encoded, _ := json.Marshal(value)
+		flat[name] = string(encoded)
+	}
+
+	walk("", tree)
+	return flat
+}
+
+// fileFingerprint returns a hash of the contents of the config file and the files it refers to that can be reloaded
+func (c config) fileFingerprint() [sha256.Size]byte {
+	h := sha256.New()
+
+	for _, path := range []string{c.file, c.Auth.ApiKeysFile, c.Auth.PolicyFile} {
+		if path == "" {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			h.Write([]byte(err.Error()))
+		}
+		h.Write(data)
+		h.Write([]byte{0})
+	}
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
@@ -0,0 +1,193 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	ortv1 "github.com/haikoschol/ort-operator-api/api/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runQuery holds the filters, sort order and pagination parameters for listing OrtRuns
+type runQuery struct {
+	repoUrl         string
+	repoUrlContains string
+	stageStatuses   map[string][]StageStatus
+	selector        labels.Selector
+	createdAfter    time.Time
+	createdBefore   time.Time
+	sortBy          string
+	limit           int64
+	continueToken   string
+}
+
+// parseRunQuery parses the query parameters of GET /runs:
+//
+//   - repoUrl: only runs for exactly this repository URL
+//   - repoUrlContains: only runs with a repository URL containing this string
+//   - analyzer, scanner, reporter: only runs where the stage has one of the given comma-separated statuses
+//   - labelSelector: a Kubernetes label selector
+//   - createdAfter, createdBefore: RFC 3339 timestamps limiting the creation time
+//   - sort: one of "name", "-name", "created" and "-created"
+//   - limit, continue: pagination, mapped onto Kubernetes list chunking
+func parseRunQuery(query url.Values) (runQuery, error) {
+	q := runQuery{
+		repoUrl:         query.Get("repoUrl"),
+		repoUrlContains: query.Get("repoUrlContains"),
+		stageStatuses:   map[string][]StageStatus{},
+		selector:        labels.Everything(),
+		sortBy:          query.Get("sort"),
+		continueToken:   query.Get("continue"),
+	}
+
+	for _, stage := range stages {
+		value := query.Get(stage)
+		if value == "" {
+			continue
+		}
+
+		for _, name := range strings.Split(value, ",") {
+			status, ok := parseStageStatus(name)
+			if !ok {
+				return q, fmt.Errorf("invalid status for %s: '%s'", stage, name)
+			}
+			q.stageStatuses[stage] = append(q.stageStatuses[stage], status)
+		}
+	}
+
+	if value := query.Get("labelSelector"); value != "" {
+		selector, err := labels.Parse(value)
+		if err != nil {
+			return q, fmt.Errorf("invalid labelSelector: %v", err)
+		}
+		q.selector = selector
+	}
+
+	if value := query.Get("createdAfter"); value != "" {
+		createdAfter, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return q, fmt.Errorf("invalid value for createdAfter: '%s'", value)
+		}
+		q.createdAfter = createdAfter
+	}
+
+	if value := query.Get("createdBefore"); value != "" {
+		createdBefore, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return q, fmt.Errorf("invalid value for createdBefore: '%s'", value)
+		}
+		q.createdBefore = createdBefore
+	}
+
+	switch q.sortBy {
+	case "", "name", "-name", "created", "-created":
+	default:
+		return q, fmt.Errorf("invalid value for sort: '%s'", q.sortBy)
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 {
+			return q, fmt.Errorf("invalid value for limit: '%s'", value)
+		}
+		q.limit = limit
+	}
+
+	// Kubernetes returns pages in the order of the names of the resources, so other orders would only apply per page
+	if q.paginated() && q.sortBy != "" && q.sortBy != "name" {
+		return q, fmt.Errorf("sort '%s' is not supported together with limit or continue", q.sortBy)
+	}
+
+	return q, nil
+}
+
+func (q runQuery) paginated() bool {
+	return q.limit > 0 || q.continueToken != ""
+}
+
+// apply returns the runs matching the filters of q in the requested order
+func (q runQuery) apply(runs []ortv1.OrtRun) []ortv1.OrtRun {
+	var result []ortv1.OrtRun
+
+	for _, run := range runs {
+		if q.matches(&run) {
+			result = append(result, run)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		switch q.sortBy {
+		case "-name":
+			return result[i].GetName() > result[j].GetName()
+		case "created":
+			return result[i].CreationTimestamp.Before(&result[j].CreationTimestamp)
+		case "-created":
+			return result[j].CreationTimestamp.Before(&result[i].CreationTimestamp)
+		default:
+			return result[i].GetName() < result[j].GetName()
+		}
+	})
+
+	return result
+}
+
+// matches checks all filters of q except the label selector, which is applied when listing the runs
+func (q runQuery) matches(run *ortv1.OrtRun) bool {
+	repoUrl := run.Spec.RepoUrl
+
+	if q.repoUrl != "" && repoUrl != q.repoUrl {
+		return false
+	}
+
+	if q.repoUrlContains != "" && !strings.Contains(repoUrl, q.repoUrlContains) {
+		return false
+	}
+
+	created := run.CreationTimestamp.Time
+
+	if !q.createdAfter.IsZero() && created.Before(q.createdAfter) {
+		return false
+	}
+
+	if !q.createdBefore.IsZero() && !created.Before(q.createdBefore) {
+		return false
+	}
+
+	actual := runStatusFromResource(run).byStage()
+
+	for stage, wanted := range q.stageStatuses {
+		if !containsStatus(wanted, actual[stage]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsStatus(statuses []StageStatus, status StageStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
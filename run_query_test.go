@@ -0,0 +1,157 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	ortv1 "github.com/haikoschol/ort-operator-api/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testRun(name, repoUrl string, created time.Time, analyzer, scanner, reporter string) ortv1.OrtRun {
+	return ortv1.OrtRun{
+		ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)},
+		Spec:       ortv1.OrtRunSpec{RepoUrl: repoUrl},
+		Status:     ortv1.OrtRunStatus{Analyzer: analyzer, Scanner: scanner, Reporter: reporter},
+	}
+}
+
+func TestParseRunQueryRejectsInvalidParameters(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"unknown status", "analyzer=Done"},
+		{"one of several statuses unknown", "scanner=Running,Done"},
+		{"invalid label selector", "labelSelector=a%20in%20b"},
+		{"invalid createdAfter", "createdAfter=yesterday"},
+		{"invalid createdBefore", "createdBefore=2023-01-01"},
+		{"unknown sort", "sort=repoUrl"},
+		{"limit not a number", "limit=ten"},
+		{"limit zero", "limit=0"},
+		{"limit negative", "limit=-1"},
+		{"sort by creation with limit", "sort=created&limit=10"},
+		{"sort by descending name with limit", "sort=-name&limit=10"},
+		{"sort by creation with continue", "sort=-created&continue=abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := parseRunQuery(query); err == nil {
+				t.Errorf("parseRunQuery(%q) succeeded, expected an error", tt.query)
+			}
+		})
+	}
+}
+
+func TestParseRunQueryAcceptsPaginationSortedByName(t *testing.T) {
+	for _, query := range []string{"limit=10", "sort=name&limit=10", "sort=name&continue=abc"} {
+		values, _ := url.ParseQuery(query)
+
+		q, err := parseRunQuery(values)
+		if err != nil {
+			t.Errorf("parseRunQuery(%q) failed: %v", query, err)
+			continue
+		}
+
+		if !q.paginated() {
+			t.Errorf("parseRunQuery(%q) is not paginated", query)
+		}
+	}
+}
+
+func TestRunQueryApply(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2023, 5, d, 12, 0, 0, 0, time.UTC) }
+
+	runs := []ortv1.OrtRun{
+		testRun("b", "https://github.com/org/app", day(2), "Succeeded", "Running", ""),
+		testRun("a", "https://github.com/org/lib", day(3), "Succeeded", "Succeeded", "Failed"),
+		testRun("d", "https://gitlab.com/org/app", day(1), "Failed", "", ""),
+		testRun("c", "https://github.com/org/app", day(4), "Running", "", ""),
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"no filters sorts by name", "", []string{"a", "b", "c", "d"}},
+		{"descending name", "sort=-name", []string{"d", "c", "b", "a"}},
+		{"created", "sort=created", []string{"d", "b", "a", "c"}},
+		{"descending created", "sort=-created", []string{"c", "a", "b", "d"}},
+		{"exact repoUrl", "repoUrl=https://github.com/org/app", []string{"b", "c"}},
+		{"repoUrlContains", "repoUrlContains=github.com", []string{"a", "b", "c"}},
+		{"stage status", "analyzer=Succeeded", []string{"a", "b"}},
+		{"several statuses of a stage", "analyzer=Failed,Running", []string{"c", "d"}},
+		{"missing status is Pending", "scanner=Pending", []string{"c", "d"}},
+		{"statuses of several stages", "analyzer=Succeeded&reporter=Failed", []string{"a"}},
+		{"createdAfter is inclusive", "createdAfter=2023-05-02T12:00:00Z", []string{"a", "b", "c"}},
+		{"createdBefore is exclusive", "createdBefore=2023-05-03T12:00:00Z", []string{"b", "d"}},
+		{
+			"filters combined with sort",
+			"repoUrlContains=/org/app&createdAfter=2023-05-01T00:00:00Z&sort=-created",
+			[]string{"c", "b", "d"},
+		},
+		{"nothing matches", "repoUrl=https://example.com&sort=created", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q, err := parseRunQuery(values)
+			if err != nil {
+				t.Fatalf("parseRunQuery(%q) failed: %v", tt.query, err)
+			}
+
+			var got []string
+			for _, run := range q.apply(runs) {
+				got = append(got, run.Name)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("apply(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunQueryApplyTreatsAbortedRunsAsAborted(t *testing.T) {
+	run := testRun("a", "https://github.com/org/app", time.Now(), "Succeeded", "Running", "")
+	run.Spec.Abort = true
+
+	values, _ := url.ParseQuery("scanner=Aborted&reporter=Aborted&analyzer=Succeeded")
+	q, err := parseRunQuery(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := q.apply([]ortv1.OrtRun{run}); len(got) != 1 {
+		t.Errorf("apply() = %v, expected the aborted run", got)
+	}
+}
@@ -17,65 +17,103 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
 	"github.com/slack-go/slack/socketmode"
-	"log"
-	"os"
-)
-
-var (
-	slackToken = os.Getenv("SLACK_TOKEN")
-	channelId  = os.Getenv("SLACK_CHANNEL_ID")
+	"log/slog"
+	"sync/atomic"
 )
 
+// slackBot posts to the channel with the ID channelId, which can be changed at runtime
 type slackBot struct {
 	oc        ortController
+	channelId *atomic.Pointer[string]
 	smHandler *socketmode.SocketmodeHandler
+	status    *botStatus
 }
 
-func newSlackBot() (slackBot, error) {
-	oc, err := newOrtController()
-	if err != nil {
-		return slackBot{}, err
-	}
-
-	client := slack.New(slackToken)
+func newSlackBot(oc ortController, cfg slackConfig) (slackBot, error) {
+	client := slack.New(cfg.Token)
+	channelId := cfg.ChannelId
 
 	attachment := slack.Attachment{
 		Text: "test",
 	}
 
-	_, _, err = client.PostMessage(channelId, slack.MsgOptionAttachments(attachment))
+	_, _, err := client.PostMessage(channelId, slack.MsgOptionAttachments(attachment))
 	if err != nil {
-		log.Printf("failed to post a message to Slack: %v\n", err)
+		slog.Error("failed to post a message", "platform", "slack", "channel", channelId, "error", err)
 	}
 
 	socketmodeClient := socketmode.New(client)
 	smHandler := socketmode.NewSocketmodeHandler(socketmodeClient)
 
-	return slackBot{
+	sb := slackBot{
 		oc,
+		&atomic.Pointer[string]{},
 		smHandler,
-	}, nil
+		&botStatus{},
+	}
+
+	sb.channelId.Store(&channelId)
+	return sb, nil
 }
 
-func (sb slackBot) run() {
+// run handles events in the background until ctx is done. The returned channel is closed once the bot has stopped.
+func (sb slackBot) run(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
 	sb.smHandler.HandleEvents(slackevents.AppMention, sb.handleMention)
+	sb.smHandler.Handle(socketmode.EventTypeConnected, sb.handleConnectionEvent)
+	sb.smHandler.Handle(socketmode.EventTypeConnectionError, sb.handleConnectionEvent)
+	sb.smHandler.Handle(socketmode.EventTypeInvalidAuth, sb.handleConnectionEvent)
+	sb.smHandler.Handle(socketmode.EventTypeDisconnect, sb.handleConnectionEvent)
+
 	go func() {
-		if err := sb.smHandler.RunEventLoop(); err != nil {
-			log.Fatal(err)
+		defer close(done)
+
+		if err := sb.smHandler.RunEventLoopContext(ctx); err != nil && ctx.Err() == nil {
+			fatal("the Slack event loop failed", "platform", "slack", "error", err)
 		}
 	}()
+
+	return done
 }
 
+// handleMention answers every mention with the help text
 func (sb slackBot) handleMention(event *socketmode.Event, client *socketmode.Client) {
+	countBotCommand("slack", "help")
+
+	channelId := *sb.channelId.Load()
+	logger := slog.With("platform", "slack", "channel", channelId, "command", "help")
+
+	if apiEvent, ok := event.Data.(slackevents.EventsAPIEvent); ok {
+		if mention, ok := apiEvent.InnerEvent.Data.(*slackevents.AppMentionEvent); ok {
+			logger = logger.With("user", mention.User)
+		}
+	}
+
+	logger.Debug("handling command")
+
 	attachment := slack.Attachment{
 		Text: chatbotHelpText,
 	}
 
 	_, _, err := client.PostMessage(channelId, slack.MsgOptionAttachments(attachment))
 	if err != nil {
-		log.Printf("failed to post a message to Slack: %v\n", err)
+		logger.Error("failed to post a message", "error", err)
 	}
 }
+
+// handleConnectionEvent records the state of the connection to Slack for the readiness check
+func (sb slackBot) handleConnectionEvent(event *socketmode.Event, client *socketmode.Client) {
+	if event.Type == socketmode.EventTypeConnected {
+		sb.status.set(nil)
+		return
+	}
+
+	err := fmt.Errorf("%s: %v", event.Type, event.Data)
+	slog.Warn("lost the connection to Slack", "platform", "slack", "error", err)
+	sb.status.set(err)
+}
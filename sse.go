@@ -0,0 +1,71 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// eventStream writes Server-Sent Events with JSON payloads to an HTTP response
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newEventStream writes the response headers for an event stream. Any other headers must be set before calling it.
+func newEventStream(w http.ResponseWriter) (eventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return eventStream{}, errors.New("response writer does not support flushing")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return eventStream{w, flusher}, nil
+}
+
+// send writes a single event. The id is omitted if it is empty.
+func (es eventStream) send(event, id string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(es.w, "event: %s\n", event); err != nil {
+		return err
+	}
+
+	if id != "" {
+		if _, err := fmt.Fprintf(es.w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(es.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+
+	es.flusher.Flush()
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright (C) 2023 Haiko Schol
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+)
+
+const serviceName = "ort-operator-api"
+
+// tracer creates the spans of the API. It uses the global tracer provider installed by setupTracing.
+var tracer = otel.Tracer("github.com/haikoschol/ort-operator-api")
+
+// attribute keys of spans, in addition to those of the OpenTelemetry semantic conventions
+const (
+	attrCluster = attribute.Key("ort.cluster")
+	attrRunName = attribute.Key("ort.run.name")
+	attrStage   = attribute.Key("ort.run.stage")
+	attrCommand = attribute.Key("ort.bot.command")
+)
+
+// setupTracing installs the W3C trace context propagator and a tracer provider sending spans to the configured
+// exporter. Without exporter, no spans are recorded. The returned function flushes the remaining spans and stops the
+// exporter.
+func setupTracing(ctx context.Context, cfg tracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch cfg.Exporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	case "file":
+		var file *os.File
+		if file, err = os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err == nil {
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the service name set here
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// the sampler defaults to sampling what the caller sampled and everything else, OTEL_TRACES_SAMPLER overrides it
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// startSpan starts a span of a method of the controller, named after the method
+func (oc ortController) startSpan(
+	ctx context.Context,
+	method string,
+	attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	attrs = append(attrs, attrCluster.String(oc.cluster))
+	return tracer.Start(ctx, "ortController."+method, trace.WithAttributes(attrs...))
+}
+
+// namespaceAttribute returns the span attribute of the namespace, which is the default namespace if it is empty
+func (oc ortController) namespaceAttribute(namespace string) attribute.KeyValue {
+	if namespace == "" {
+		namespace = oc.defaultNamespace
+	}
+	return semconv.K8SNamespaceName(namespace)
+}
+
+// endSpan records err in span, if it is not nil, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}